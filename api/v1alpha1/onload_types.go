@@ -23,15 +23,79 @@ type BuildArg struct {
 	Value string `json:"value"`
 }
 
+// TLSOptions determines how to access a container image registry.
+type TLSOptions struct {
+	// +optional
+	// Insecure allows the registry to be accessed using plain HTTP.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// +optional
+	// InsecureSkipTLSVerify accepts any certificate provided by the registry.
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
 // Build is a subset of the build options presented by the Kernel Module
 // Management operator.
+// +kubebuilder:validation:XValidation:message="Exactly one of DockerfileConfigMap and Template is required",rule="has(self.dockerfileConfigMap) != has(self.template)"
 type OnloadKernelBuild struct {
 	// +optional
 	// BuildArgs is an array of build variables that are provided to the image building backend.
 	BuildArgs []BuildArg `json:"buildArgs"`
 
-	// ConfigMap that holds Dockerfile contents
-	DockerfileConfigMap *v1.LocalObjectReference `json:"dockerfileConfigMap"`
+	// +optional
+	// ConfigMap that holds Dockerfile contents. Mutually exclusive with
+	// Template.
+	DockerfileConfigMap *v1.LocalObjectReference `json:"dockerfileConfigMap,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Enum=dtk-ubi;dtk-only;ubuntu
+	// Template is the name of a Dockerfile shipped with the Onload Operator.
+	// The operator creates a ConfigMap holding the Dockerfile, which builds
+	// the modules from SourceImage. Mutually exclusive with
+	// DockerfileConfigMap. The mkdist-direct sample isn't offered, as it
+	// builds from a release tarball rather than a source image; use it through
+	// DockerfileConfigMap instead.
+	Template string `json:"template,omitempty"`
+
+	// +optional
+	// SourceImage is the Onload source image used by Template. Defaults to
+	// `docker.io/onload/onload-source` tagged with the Onload version.
+	SourceImage string `json:"sourceImage,omitempty"`
+
+	// +optional
+	// Secrets is a list of secrets to be made available to the build system,
+	// eg. for access to private source repositories. Use ImageRepoSecret for
+	// container registry credentials.
+	Secrets []v1.LocalObjectReference `json:"secrets,omitempty"`
+
+	// +optional
+	// BaseImageRegistryTLS determines how to access the registries of the base
+	// images used by the Dockerfile.
+	BaseImageRegistryTLS *TLSOptions `json:"baseImageRegistryTLS,omitempty"`
+}
+
+// OnloadKernelSign is a subset of the signing options presented by the Kernel
+// Module Management operator. Signing is required for the kernel modules to be
+// loaded on nodes with Secure Boot enabled.
+type OnloadKernelSign struct {
+	// +optional
+	// UnsignedImage is the image that contains the unsigned kernel modules.
+	// Ignored if a Build is present, required otherwise.
+	UnsignedImage string `json:"unsignedImage,omitempty"`
+
+	// KeySecret is a secret containing the private key used to sign the
+	// kernel modules.
+	KeySecret *v1.LocalObjectReference `json:"keySecret"`
+
+	// CertSecret is a secret containing the public key used to sign the
+	// kernel modules.
+	CertSecret *v1.LocalObjectReference `json:"certSecret"`
+
+	// +optional
+	// FilesToSign is a list of paths inside the image of the kernel modules to
+	// sign, eg. `/opt/lib/modules/${KERNEL_FULL_VERSION}/extra/onload.ko`. If empty,
+	// all kernel modules are signed.
+	FilesToSign []string `json:"filesToSign,omitempty"`
 }
 
 type OnloadKernelMapping struct {
@@ -55,6 +119,21 @@ type OnloadKernelMapping struct {
 	// location specified by the `KernelModuleImage` parameter.
 	// If empty, no builds will take place.
 	Build *OnloadKernelBuild `json:"build,omitempty"`
+
+	// +optional
+	// Sign specifies the parameters that are to be passed to the Kernel Module
+	// Management operator when signing the kernel modules for Secure Boot.
+	// The signed modules are written to the location specified by the
+	// `KernelModuleImage` parameter, which is also used for the SFC kernel
+	// module.
+	// If empty, no signing will take place. Not supported by the native module
+	// loader.
+	Sign *OnloadKernelSign `json:"sign,omitempty"`
+
+	// +optional
+	// RegistryTLS determines how to access the registry of the
+	// KernelModuleImage.
+	RegistryTLS *TLSOptions `json:"registryTLS,omitempty"`
 }
 
 // OnloadSpec defines the desired state of Onload
@@ -65,6 +144,14 @@ type OnloadSpec struct {
 	// versions on the nodes in the cluster.
 	KernelMappings []OnloadKernelMapping `json:"kernelMappings"`
 
+	// +optional
+	// SkipUnmatchedNodes prevents the Onload kernel modules from being
+	// scheduled onto selected nodes whose kernel version matches none, or
+	// more than one, of the KernelMappings, removing them from nodes they
+	// were already scheduled onto once the pods using Onload are evicted.
+	// Such nodes are reported in the status either way.
+	SkipUnmatchedNodes bool `json:"skipUnmatchedNodes,omitempty"`
+
 	// UserImage is the image that contains the built userland objects, used
 	// within the Onload Device Plugin DaemonSet.
 	UserImage string `json:"userImage"`
@@ -77,6 +164,16 @@ type OnloadSpec struct {
 	// More info: https://kubernetes.io/docs/concepts/containers/images#updating-images
 	ImagePullPolicy v1.PullPolicy `json:"imagePullPolicy,omitempty"`
 
+	// +optional
+	// ImagePullSecrets is a list of references to secrets used to pull the
+	// UserImage.
+	ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// +optional
+	// ImageRepoSecret is a reference to a secret used to pull the
+	// KernelModuleImage and to push it after an in-cluster build.
+	ImageRepoSecret *v1.LocalObjectReference `json:"imageRepoSecret,omitempty"`
+
 	// +optional
 	// ControlPlane allows fine-tuning of the Onload control plane server.
 	ControlPlane *ControlPlaneSpec `json:"controlPlane,omitempty"`
@@ -145,6 +242,79 @@ type DevicePluginSpec struct {
 	// filesystem.
 	// +kubebuilder:default=/usr/lib64
 	LibMountPath *string `json:"libMounthPath,omitempty"`
+
+	// +optional
+	// ExtraLibMountPaths are further locations to mount Onload libraries in
+	// the container's filesystem, for distros which don't look in
+	// LibMountPath, eg. `/usr/lib/x86_64-linux-gnu` for Debian and Ubuntu.
+	ExtraLibMountPaths []string `json:"extraLibMountPaths,omitempty"`
+
+	// +optional
+	// Lib32MountPath is the location to mount 32-bit Onload libraries in the
+	// container's filesystem, if they are installed on the host. An empty
+	// string doesn't mount them.
+	// +kubebuilder:default=/usr/lib
+	Lib32MountPath *string `json:"lib32MountPath,omitempty"`
+
+	// +optional
+	// ToolMounts are Onload diagnostic tools, eg. `onload_stackdump`,
+	// `onload_tcpdump`, `onload_fuser` and `orm_json`, to mount in the
+	// container's filesystem at `<baseMountPath>/<binMountpath>` along with
+	// any helpers they run. They are mounted whether or not MountOnload is set.
+	ToolMounts []string `json:"toolMounts,omitempty"`
+
+	// +optional
+	// PerInterface makes the Onload Device Plugin advertise a resource for
+	// each accelerated interface, eg. `amd.com/onload-enp2s0f0`, instead of
+	// the shared `amd.com/onload` resource. Pods using one of these resources
+	// can only accelerate that interface.
+	// +kubebuilder:default:=false
+	PerInterface *bool `json:"perInterface,omitempty"`
+
+	// +optional
+	// CDI makes the Onload Device Plugin write a Container Device Interface
+	// spec to `/var/run/cdi` on the host and allocate its device to pods,
+	// rather than giving the device nodes, mounts and environment variables
+	// directly. Requires a container runtime supporting CDI and the kubelet's
+	// DevicePluginCDIDevices feature gate.
+	// +kubebuilder:default:=false
+	CDI *bool `json:"cdi,omitempty"`
+
+	// +optional
+	// TCPDirect makes the Onload Device Plugin advertise the
+	// `amd.com/tcpdirect` resource, giving pods the TCPDirect library and the
+	// devices it needs from the UserImage. LD_PRELOAD isn't set, as
+	// applications link against TCPDirect themselves.
+	// +kubebuilder:default:=false
+	TCPDirect *bool `json:"tcpdirect,omitempty"`
+
+	// +optional
+	// EFVI makes the Onload Device Plugin advertise the `amd.com/efvi`
+	// resource, giving pods the ef_vi library and the device it needs from the
+	// UserImage. LD_PRELOAD isn't set, as applications link against ef_vi
+	// themselves.
+	// +kubebuilder:default:=false
+	EFVI *bool `json:"efvi,omitempty"`
+
+	// +optional
+	// ResourceName is the name of the resource the Onload Device Plugin
+	// advertises, which also prefixes the per-interface resources. Giving
+	// Onload CRs on different nodes different names lets pods choose between
+	// flavours of Onload, eg. `amd.com/enterprise-onload`. The TCPDirect and
+	// ef_vi resources are not renamed.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?/[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`
+	// +kubebuilder:default=amd.com/onload
+	ResourceName *string `json:"resourceName,omitempty"`
+
+	// +optional
+	// Profile is the name of a ConfigMap, in the Onload CR's namespace,
+	// holding an Onload profile such as `config/samples/profiles/latency.yaml`.
+	// Each of its keys is an environment variable, eg. `EF_POLL_USEC`, set in
+	// pods given the Onload resource, unless the Onload Device Plugin sets it
+	// itself. Changes to the ConfigMap apply to pods scheduled from then on.
+	// Setting or renaming the profile applies once the Onload Device Plugin's
+	// pods are recreated.
+	Profile *string `json:"profile,omitempty"`
 }
 
 // Spec is the top-level specification for Onload and related products that are
@@ -169,6 +339,15 @@ type Spec struct {
 
 // OnloadStatus defines the observed state of Onload
 type OnloadStatus struct {
+	// +optional
+	// UnmatchedNodes lists the selected nodes whose kernel version matches
+	// none of the KernelMappings.
+	UnmatchedNodes []string `json:"unmatchedNodes,omitempty"`
+
+	// +optional
+	// AmbiguousNodes lists the selected nodes whose kernel version matches
+	// more than one of the KernelMappings. The first match is used.
+	AmbiguousNodes []string `json:"ambiguousNodes,omitempty"`
 }
 
 type DevicePluginStatus struct {
@@ -87,6 +87,51 @@ func (in *DevicePluginSpec) DeepCopyInto(out *DevicePluginSpec) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.ExtraLibMountPaths != nil {
+		in, out := &in.ExtraLibMountPaths, &out.ExtraLibMountPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Lib32MountPath != nil {
+		in, out := &in.Lib32MountPath, &out.Lib32MountPath
+		*out = new(string)
+		**out = **in
+	}
+	if in.ToolMounts != nil {
+		in, out := &in.ToolMounts, &out.ToolMounts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PerInterface != nil {
+		in, out := &in.PerInterface, &out.PerInterface
+		*out = new(bool)
+		**out = **in
+	}
+	if in.CDI != nil {
+		in, out := &in.CDI, &out.CDI
+		*out = new(bool)
+		**out = **in
+	}
+	if in.TCPDirect != nil {
+		in, out := &in.TCPDirect, &out.TCPDirect
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EFVI != nil {
+		in, out := &in.EFVI, &out.EFVI
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ResourceName != nil {
+		in, out := &in.ResourceName, &out.ResourceName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Profile != nil {
+		in, out := &in.Profile, &out.Profile
+		*out = new(string)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DevicePluginSpec.
@@ -154,6 +199,16 @@ func (in *OnloadKernelBuild) DeepCopyInto(out *OnloadKernelBuild) {
 		*out = new(v1.LocalObjectReference)
 		**out = **in
 	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.BaseImageRegistryTLS != nil {
+		in, out := &in.BaseImageRegistryTLS, &out.BaseImageRegistryTLS
+		*out = new(TLSOptions)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnloadKernelBuild.
@@ -179,6 +234,16 @@ func (in *OnloadKernelMapping) DeepCopyInto(out *OnloadKernelMapping) {
 		*out = new(OnloadKernelBuild)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Sign != nil {
+		in, out := &in.Sign, &out.Sign
+		*out = new(OnloadKernelSign)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RegistryTLS != nil {
+		in, out := &in.RegistryTLS, &out.RegistryTLS
+		*out = new(TLSOptions)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnloadKernelMapping.
@@ -191,6 +256,36 @@ func (in *OnloadKernelMapping) DeepCopy() *OnloadKernelMapping {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnloadKernelSign) DeepCopyInto(out *OnloadKernelSign) {
+	*out = *in
+	if in.KeySecret != nil {
+		in, out := &in.KeySecret, &out.KeySecret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.CertSecret != nil {
+		in, out := &in.CertSecret, &out.CertSecret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.FilesToSign != nil {
+		in, out := &in.FilesToSign, &out.FilesToSign
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnloadKernelSign.
+func (in *OnloadKernelSign) DeepCopy() *OnloadKernelSign {
+	if in == nil {
+		return nil
+	}
+	out := new(OnloadKernelSign)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OnloadList) DeepCopyInto(out *OnloadList) {
 	*out = *in
@@ -233,6 +328,16 @@ func (in *OnloadSpec) DeepCopyInto(out *OnloadSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImageRepoSecret != nil {
+		in, out := &in.ImageRepoSecret, &out.ImageRepoSecret
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
 	if in.ControlPlane != nil {
 		in, out := &in.ControlPlane, &out.ControlPlane
 		*out = new(ControlPlaneSpec)
@@ -253,6 +358,16 @@ func (in *OnloadSpec) DeepCopy() *OnloadSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OnloadStatus) DeepCopyInto(out *OnloadStatus) {
 	*out = *in
+	if in.UnmatchedNodes != nil {
+		in, out := &in.UnmatchedNodes, &out.UnmatchedNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AmbiguousNodes != nil {
+		in, out := &in.AmbiguousNodes, &out.AmbiguousNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnloadStatus.
@@ -314,7 +429,7 @@ func (in *Status) DeepCopyInto(out *Status) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	out.Onload = in.Onload
+	in.Onload.DeepCopyInto(&out.Onload)
 	out.DevicePlugin = in.DevicePlugin
 }
 
@@ -327,3 +442,18 @@ func (in *Status) DeepCopy() *Status {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSOptions) DeepCopyInto(out *TLSOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSOptions.
+func (in *TLSOptions) DeepCopy() *TLSOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSOptions)
+	in.DeepCopyInto(out)
+	return out
+}
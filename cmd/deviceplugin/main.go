@@ -3,7 +3,12 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/golang/glog"
 
@@ -14,6 +19,9 @@ func main() {
 
 	config := deviceplugin.DefaultConfig
 
+	flag.StringVar(&config.ResourceName, "resourceName",
+		deviceplugin.DefaultConfig.ResourceName,
+		"Name of the Onload resource to advertise, which also prefixes per-interface resources")
 	flag.IntVar(&config.MaxPodsPerNode, "maxPods",
 		deviceplugin.DefaultConfig.MaxPodsPerNode,
 		"Number of Onload resources to advertise on each node")
@@ -38,6 +46,56 @@ func main() {
 	flag.StringVar(&config.LibMountPath, "libMountPath",
 		deviceplugin.DefaultConfig.LibMountPath,
 		"Location to mount onload libraries in the container's filesystem")
+	flag.Func("extraLibMountPaths",
+		"Comma-separated further locations to mount onload libraries in the container's filesystem",
+		func(value string) error {
+			config.ExtraLibMountPaths = strings.Split(value, ",")
+			return nil
+		})
+	flag.StringVar(&config.Lib32MountPath, "lib32MountPath",
+		deviceplugin.DefaultConfig.Lib32MountPath,
+		"Location to mount 32-bit onload libraries in the container's filesystem, or empty not to")
+	flag.StringVar(&config.ProfileDir, "profileDir",
+		deviceplugin.DefaultConfig.ProfileDir,
+		"Directory holding an onload profile, with a file per environment variable to set in the pod")
+	flag.Func("toolMounts",
+		"Comma-separated onload diagnostic tools to mount into the container's filesystem",
+		func(value string) error {
+			config.ToolMounts = strings.Split(value, ",")
+			return nil
+		})
+	flag.StringVar(&config.SysfsRoot, "sysfsRoot",
+		deviceplugin.DefaultConfig.SysfsRoot,
+		"Location of sysfs, used to discover the nics on the node")
+	flag.BoolVar(&config.PerInterface, "perInterface",
+		deviceplugin.DefaultConfig.PerInterface,
+		"Should the device plugin advertise a resource for each sfc interface")
+	flag.BoolVar(&config.CDI, "cdi",
+		deviceplugin.DefaultConfig.CDI,
+		"Should the device plugin write a CDI spec and allocate its device to pods")
+	flag.BoolVar(&config.TCPDirect, "tcpdirect",
+		deviceplugin.DefaultConfig.TCPDirect,
+		"Should the device plugin advertise a resource giving pods TCPDirect")
+	flag.BoolVar(&config.EFVI, "efvi",
+		deviceplugin.DefaultConfig.EFVI,
+		"Should the device plugin advertise a resource giving pods ef_vi")
+	flag.StringVar(&config.CDISpecDir, "cdiSpecDir",
+		deviceplugin.DefaultConfig.CDISpecDir,
+		"Directory to write the CDI spec in")
+	flag.StringVar(&config.MetricsAddress, "metricsAddress",
+		deviceplugin.DefaultConfig.MetricsAddress,
+		"Address to serve Prometheus metrics on, such as :9156, or empty not to")
+	flag.StringVar(&config.DebugAddress, "debugAddress",
+		deviceplugin.DefaultConfig.DebugAddress,
+		"Local address to serve the device plugin's state on, such as 127.0.0.1:9157, or empty not to")
+	flag.StringVar(&config.PodResourcesSocket, "podResourcesSocket",
+		deviceplugin.DefaultConfig.PodResourcesSocket,
+		"The kubelet's PodResources socket, used to track which pods use onload, or empty not to")
+	flag.StringVar(&config.NodeName, "nodeName", os.Getenv("NODE_NAME"),
+		"Name of this node, annotated with the pods using onload, or empty not to")
+	flag.StringVar(&config.ConfigFile, "config",
+		deviceplugin.DefaultConfig.ConfigFile,
+		"YAML or JSON file whose settings override the flags, reloaded when it changes")
 	flag.Parse()
 	err := flag.Lookup("logtostderr").Value.Set("true")
 	if err != nil {
@@ -50,5 +108,15 @@ func main() {
 	}
 	glog.Infof("SFC interfaces: %s", manager.GetInterfaces())
 	glog.Infof("Device files: %s", manager.GetDeviceFiles())
-	manager.Run() /* Blocks forever */
+
+	// Stop cleanly when the pod is deleted, so that the kubelet isn't left
+	// with our sockets.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	err = manager.Run(ctx)
+	if err != nil {
+		glog.Errorf("Device plugin failed: %v", err)
+		glog.Flush()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	"context"
+	"path"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+const devicePluginConfigNameSuffix = "-onload-device-plugin-config"
+
+const devicePluginConfigComponent = "device-plugin-config"
+
+// Where the Onload Device Plugin's config file is mounted in its container.
+const (
+	devicePluginConfigDir  = "/etc/onload-device-plugin"
+	devicePluginConfigFile = "config.yaml"
+)
+
+// Where the ConfigMap holding the Onload profile is mounted in the Onload
+// Device Plugin's container.
+const devicePluginProfileDir = "/etc/onload-profile"
+
+// devicePluginConfig is the Onload Device Plugin's config file, holding the
+// settings of a DevicePluginSpec. The Onload Device Plugin reloads it when it
+// changes, so most settings can be changed without restarting it.
+type devicePluginConfig struct {
+	MaxPodsPerNode     *int     `json:"maxPodsPerNode,omitempty"`
+	SetPreload         *bool    `json:"setPreload,omitempty"`
+	MountOnload        *bool    `json:"mountOnload,omitempty"`
+	HostOnloadPath     *string  `json:"hostOnloadPath,omitempty"`
+	BaseMountPath      *string  `json:"baseMountPath,omitempty"`
+	BinMountPath       *string  `json:"binMountPath,omitempty"`
+	LibMountPath       *string  `json:"libMountPath,omitempty"`
+	ExtraLibMountPaths []string `json:"extraLibMountPaths,omitempty"`
+	Lib32MountPath     *string  `json:"lib32MountPath,omitempty"`
+	ToolMounts         []string `json:"toolMounts,omitempty"`
+	PerInterface       *bool    `json:"perInterface,omitempty"`
+	CDI                *bool    `json:"cdi,omitempty"`
+	TCPDirect          *bool    `json:"tcpdirect,omitempty"`
+	EFVI               *bool    `json:"efvi,omitempty"`
+	ResourceName       *string  `json:"resourceName,omitempty"`
+	ProfileDir         *string  `json:"profileDir,omitempty"`
+}
+
+// Returns the path of the config file in the Onload Device Plugin container.
+func devicePluginConfigPath() string {
+	return path.Join(devicePluginConfigDir, devicePluginConfigFile)
+}
+
+// Returns the ConfigMap holding the Onload Device Plugin's config file.
+func devicePluginConfigMap(onload *onloadv1alpha1.Onload) (*corev1.ConfigMap, error) {
+	spec := onload.Spec.DevicePlugin
+	var profileDir *string
+	if spec.Profile != nil {
+		profileDir = ptr.To(devicePluginProfileDir)
+	}
+	config, err := yaml.Marshal(devicePluginConfig{
+		MaxPodsPerNode:     spec.MaxPodsPerNode,
+		SetPreload:         spec.SetPreload,
+		MountOnload:        spec.MountOnload,
+		HostOnloadPath:     spec.HostOnloadPath,
+		BaseMountPath:      spec.BaseMountPath,
+		BinMountPath:       spec.BinMountPath,
+		LibMountPath:       spec.LibMountPath,
+		ExtraLibMountPaths: spec.ExtraLibMountPaths,
+		Lib32MountPath:     spec.Lib32MountPath,
+		ToolMounts:         spec.ToolMounts,
+		PerInterface:       spec.PerInterface,
+		CDI:                spec.CDI,
+		TCPDirect:          spec.TCPDirect,
+		EFVI:               spec.EFVI,
+		ResourceName:       spec.ResourceName,
+		ProfileDir:         profileDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onload.Name + devicePluginConfigNameSuffix,
+			Namespace: onload.Namespace,
+			Labels:    baseLabels(onload.Name, onload.Namespace, devicePluginConfigComponent),
+		},
+		Data: map[string]string{
+			devicePluginConfigFile: string(config),
+		},
+	}, nil
+}
+
+// reconcileDevicePluginConfig creates, or updates, the ConfigMap holding the
+// Onload Device Plugin's config file. Updates are picked up by the running
+// device plugins, so don't need the Onload Device Plugin DaemonSet to change.
+func (r *OnloadReconciler) reconcileDevicePluginConfig(ctx context.Context, onload *onloadv1alpha1.Onload,
+) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	configMap, err := devicePluginConfigMap(onload)
+	if err != nil {
+		log.Error(err, "Failed to generate Device Plugin config")
+		return nil, err
+	}
+
+	existing := &corev1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Name: configMap.Name, Namespace: configMap.Namespace},
+		existing)
+	if apierrors.IsNotFound(err) {
+		err = ctrl.SetControllerReference(onload, configMap, r.Scheme)
+		if err != nil {
+			log.Error(err, "Failed to set owner of Device Plugin config")
+			return nil, err
+		}
+
+		err = r.Create(ctx, configMap)
+		if err != nil {
+			log.Error(err, "Failed to create Device Plugin config", "ConfigMap", configMap.Name)
+			return nil, err
+		}
+
+		log.Info("Created Device Plugin config", "ConfigMap", configMap.Name)
+		return &ctrl.Result{Requeue: true}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Device Plugin config", "ConfigMap", configMap.Name)
+		return nil, err
+	}
+
+	if reflect.DeepEqual(existing.Data, configMap.Data) {
+		return nil, nil
+	}
+
+	existing.Data = configMap.Data
+	err = r.Update(ctx, existing)
+	if err != nil {
+		log.Error(err, "Failed to update Device Plugin config", "ConfigMap", configMap.Name)
+		return nil, err
+	}
+	log.Info("Updated Device Plugin config", "ConfigMap", configMap.Name)
+	return nil, nil
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+var _ = Describe("Testing the Device Plugin config", func() {
+	var onload *onloadv1alpha1.Onload
+
+	BeforeEach(func() {
+		onload = &onloadv1alpha1.Onload{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "name",
+				Namespace: "namespace",
+			},
+		}
+	})
+
+	It("should only contain the settings given", func() {
+		onload.Spec.DevicePlugin = onloadv1alpha1.DevicePluginSpec{
+			MaxPodsPerNode: ptr.To(10),
+			SetPreload:     ptr.To(false),
+			LibMountPath:   ptr.To("/usr/lib"),
+		}
+
+		configMap, err := devicePluginConfigMap(onload)
+		Expect(err).Should(Succeed())
+		Expect(configMap.Name).Should(Equal("name-onload-device-plugin-config"))
+		Expect(configMap.Namespace).Should(Equal("namespace"))
+		Expect(configMap.Data).Should(Equal(map[string]string{
+			"config.yaml": "libMountPath: /usr/lib\nmaxPodsPerNode: 10\nsetPreload: false\n",
+		}))
+	})
+
+	It("should list the extra library mount paths", func() {
+		onload.Spec.DevicePlugin.ExtraLibMountPaths = []string{"/usr/lib/x86_64-linux-gnu"}
+
+		configMap, err := devicePluginConfigMap(onload)
+		Expect(err).Should(Succeed())
+		Expect(configMap.Data).Should(Equal(map[string]string{
+			"config.yaml": "extraLibMountPaths:\n- /usr/lib/x86_64-linux-gnu\n",
+		}))
+	})
+
+	It("should mount the config file where the Device Plugin reads it", func() {
+		Expect(devicePluginConfigPath()).Should(Equal("/etc/onload-device-plugin/config.yaml"))
+	})
+})
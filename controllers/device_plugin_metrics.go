@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+const devicePluginMetricsNameSuffix = "-onload-device-plugin-metrics"
+
+const devicePluginMetricsComponent = "device-plugin-metrics"
+
+// The port the Onload Device Plugin serves metrics on. As the Onload Device
+// Plugin uses the host network, this is a port on each node.
+const devicePluginMetricsPort = 9156
+
+const devicePluginMetricsPortName = "metrics"
+
+// The port the Onload Device Plugin serves its state on, for debugging. It is
+// only served on the loopback address, so is reached by port-forwarding.
+const devicePluginDebugPort = 9157
+
+// The Prometheus Operator's ServiceMonitor, which is only created if its CRD
+// is installed in the cluster.
+var serviceMonitorGVK = schema.GroupVersionKind{
+	Group:   "monitoring.coreos.com",
+	Version: "v1",
+	Kind:    "ServiceMonitor",
+}
+
+// Returns the headless Service selecting every Onload Device Plugin pod, so
+// that each can be scraped.
+func devicePluginMetricsService(onload *onloadv1alpha1.Onload) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onload.Name + devicePluginMetricsNameSuffix,
+			Namespace: onload.Namespace,
+			Labels:    baseLabels(onload.Name, onload.Namespace, devicePluginMetricsComponent),
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{
+				onloadLabelPrefix + "name": onload.Name + devicePluginNameSuffix,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       devicePluginMetricsPortName,
+					Port:       devicePluginMetricsPort,
+					TargetPort: intstr.FromString(devicePluginMetricsPortName),
+					Protocol:   corev1.ProtocolTCP,
+				},
+			},
+		},
+	}
+}
+
+// Returns the ServiceMonitor telling Prometheus to scrape the Onload Device
+// Plugin pods selected by the metrics Service.
+func devicePluginServiceMonitor(onload *onloadv1alpha1.Onload) *unstructured.Unstructured {
+	labels := baseLabels(onload.Name, onload.Namespace, devicePluginMetricsComponent)
+	matchLabels := map[string]interface{}{}
+	for key, value := range labels {
+		matchLabels[key] = value
+	}
+
+	serviceMonitor := &unstructured.Unstructured{}
+	serviceMonitor.SetGroupVersionKind(serviceMonitorGVK)
+	serviceMonitor.SetName(onload.Name + devicePluginMetricsNameSuffix)
+	serviceMonitor.SetNamespace(onload.Namespace)
+	serviceMonitor.SetLabels(labels)
+	serviceMonitor.Object["spec"] = map[string]interface{}{
+		"endpoints": []interface{}{
+			map[string]interface{}{
+				"port": devicePluginMetricsPortName,
+				"path": "/metrics",
+			},
+		},
+		"selector": map[string]interface{}{
+			"matchLabels": matchLabels,
+		},
+	}
+	return serviceMonitor
+}
+
+// Creates obj, owned by onload, unless it already exists. Returns true if it
+// was created.
+func (r *OnloadReconciler) createOwnedIfMissing(ctx context.Context, onload *onloadv1alpha1.Onload,
+	obj client.Object, existing client.Object,
+) (bool, error) {
+	err := r.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()},
+		existing)
+	if err == nil {
+		return false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	err = ctrl.SetControllerReference(onload, obj, r.Scheme)
+	if err != nil {
+		return false, fmt.Errorf("failed to set owner (%w)", err)
+	}
+	err = r.Create(ctx, obj)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reconcileDevicePluginMetrics creates the Service exposing the Onload Device
+// Plugin's metrics and, if the Prometheus Operator is installed, the
+// ServiceMonitor scraping them.
+func (r *OnloadReconciler) reconcileDevicePluginMetrics(ctx context.Context, onload *onloadv1alpha1.Onload,
+) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	service := devicePluginMetricsService(onload)
+	created, err := r.createOwnedIfMissing(ctx, onload, service, &corev1.Service{})
+	if err != nil {
+		log.Error(err, "Failed to create Device Plugin metrics Service", "Service", service.Name)
+		return nil, err
+	} else if created {
+		log.Info("Created Device Plugin metrics Service", "Service", service.Name)
+		return &ctrl.Result{Requeue: true}, nil
+	}
+
+	serviceMonitor := devicePluginServiceMonitor(onload)
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(serviceMonitorGVK)
+	created, err = r.createOwnedIfMissing(ctx, onload, serviceMonitor, existing)
+	if meta.IsNoMatchError(err) {
+		log.V(1).Info("ServiceMonitor is not available, not creating one for the Device Plugin")
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to create Device Plugin ServiceMonitor", "ServiceMonitor", serviceMonitor.GetName())
+		return nil, err
+	} else if created {
+		log.Info("Created Device Plugin ServiceMonitor", "ServiceMonitor", serviceMonitor.GetName())
+		return &ctrl.Result{Requeue: true}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+	mock_client "github.com/Xilinx-CNS/kubernetes-onload/mocks/client"
+)
+
+var _ = Describe("Testing the Device Plugin metrics", func() {
+	var (
+		onload     *onloadv1alpha1.Onload
+		mockClient *mock_client.MockClient
+		r          *OnloadReconciler
+	)
+
+	BeforeEach(func() {
+		onload = &onloadv1alpha1.Onload{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "name",
+				Namespace: "namespace",
+			},
+		}
+
+		scheme := runtime.NewScheme()
+		Expect(onloadv1alpha1.AddToScheme(scheme)).Should(Succeed())
+
+		mockCtrl := gomock.NewController(GinkgoT())
+		mockClient = mock_client.NewMockClient(mockCtrl)
+		r = &OnloadReconciler{
+			Client: mockClient,
+			Scheme: scheme,
+		}
+	})
+
+	It("should select the device plugin pods", func() {
+		service := devicePluginMetricsService(onload)
+		Expect(service.Name).Should(Equal("name-onload-device-plugin-metrics"))
+		Expect(service.Spec.ClusterIP).Should(Equal(corev1.ClusterIPNone))
+		Expect(service.Spec.Selector).Should(Equal(map[string]string{
+			"onload.amd.com/name": "name-onload-device-plugin-ds",
+		}))
+
+		serviceMonitor := devicePluginServiceMonitor(onload)
+		matchLabels, found, err := unstructured.NestedStringMap(serviceMonitor.Object,
+			"spec", "selector", "matchLabels")
+		Expect(err).Should(Succeed())
+		Expect(found).Should(BeTrue())
+		Expect(matchLabels).Should(Equal(service.Labels))
+	})
+
+	It("should create the Service", func() {
+		notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "services"}, "")
+		mockClient.EXPECT().
+			Get(gomock.Any(), gomock.Any(), &corev1.Service{}, gomock.Any()).
+			Return(notFound).
+			Times(1)
+		mockClient.EXPECT().
+			Create(gomock.Any(), gomock.AssignableToTypeOf(&corev1.Service{}), gomock.Any()).
+			Return(nil).
+			Times(1)
+
+		Expect(r.reconcileDevicePluginMetrics(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+	})
+
+	It("should skip the ServiceMonitor without the Prometheus Operator", func() {
+		mockClient.EXPECT().
+			Get(gomock.Any(), gomock.Any(), &corev1.Service{}, gomock.Any()).
+			Return(nil).
+			Times(1)
+		mockClient.EXPECT().
+			Get(gomock.Any(), gomock.Any(), gomock.AssignableToTypeOf(&unstructured.Unstructured{}),
+				gomock.Any()).
+			Return(&meta.NoKindMatchError{GroupKind: serviceMonitorGVK.GroupKind()}).
+			Times(1)
+
+		Expect(r.reconcileDevicePluginMetrics(ctx, onload)).Should(BeNil())
+	})
+})
@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+// The volumes of the Onload Device Plugin DaemonSet which depend on settings
+// of the Onload CR that can change after the DaemonSet is created.
+const (
+	hostOnloadVolumeName          = "host-onload"
+	cdiVolumeName                 = "cdi-specs"
+	devicePluginProfileVolumeName = "onload-profile"
+)
+
+// Where the CDI specs are written on the host, where the container runtime
+// reads them, and in the Onload Device Plugin's container.
+const cdiSpecDir = "/var/run/cdi"
+
+const defaultHostOnloadPath = "/opt/onload"
+
+// Returns the path on the host that the Onload Device Plugin copies the
+// Onload files to.
+func devicePluginHostOnloadPath(onload *onloadv1alpha1.Onload) string {
+	return ptr.Deref(onload.Spec.DevicePlugin.HostOnloadPath, defaultHostOnloadPath)
+}
+
+// Returns the lifecycle of the Onload Device Plugin's container, which manages
+// the Onload files on the host.
+func devicePluginLifecycle(onload *onloadv1alpha1.Onload) *corev1.Lifecycle {
+	hostOnloadPath := devicePluginHostOnloadPath(onload)
+	return &corev1.Lifecycle{
+		PostStart: &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{
+					"/bin/sh", "-c",
+					fmt.Sprintf(`set -e;
+				chcon --type container_file_t --recursive %s ||
+				echo "chcon failed. System may not be SELinux enabled.";`, hostOnloadPath),
+				},
+			},
+		},
+		PreStop: &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{
+					"/bin/sh", "-c",
+					fmt.Sprintf(`set -e;
+				rm -r %s;`, hostOnloadPath),
+				},
+			},
+		},
+	}
+}
+
+// Returns the volumes of the Onload Device Plugin DaemonSet which depend on
+// settings that can change, and their mounts in the Onload Device Plugin's
+// container.
+//
+// The profile's ConfigMap is mounted, rather than copied into the config file,
+// so that the kubelet keeps it up to date. It is optional so that the Onload
+// Device Plugin can start before the ConfigMap is created.
+func devicePluginVolumes(onload *onloadv1alpha1.Onload) ([]corev1.Volume, []corev1.VolumeMount) {
+	hostOnloadPath := devicePluginHostOnloadPath(onload)
+	volumes := []corev1.Volume{
+		{
+			Name: hostOnloadVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: hostOnloadPath,
+					Type: ptr.To(corev1.HostPathDirectoryOrCreate),
+				},
+			},
+		},
+	}
+	mounts := []corev1.VolumeMount{
+		{
+			MountPath: hostOnloadPath,
+			Name:      hostOnloadVolumeName,
+		},
+	}
+
+	if profile := onload.Spec.DevicePlugin.Profile; profile != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: devicePluginProfileVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: *profile,
+					},
+					Optional: ptr.To(true),
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			MountPath: devicePluginProfileDir,
+			Name:      devicePluginProfileVolumeName,
+			ReadOnly:  true,
+		})
+	}
+
+	if ptr.Deref(onload.Spec.DevicePlugin.CDI, false) {
+		volumes = append(volumes, corev1.Volume{
+			Name: cdiVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{
+					Path: cdiSpecDir,
+					Type: ptr.To(corev1.HostPathDirectoryOrCreate),
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			MountPath: cdiSpecDir,
+			Name:      cdiVolumeName,
+		})
+	}
+
+	return volumes, mounts
+}
+
+// Returns true if the volume is one of those returned by devicePluginVolumes.
+func isReconciledVolume(name string) bool {
+	return name == hostOnloadVolumeName || name == cdiVolumeName || name == devicePluginProfileVolumeName
+}
+
+// Returns true if the existing volume matches the one wanted, ignoring any
+// fields defaulted by the API server.
+func volumeMatches(existing corev1.Volume, wanted corev1.Volume) bool {
+	switch {
+	case wanted.HostPath != nil:
+		return existing.HostPath != nil && existing.HostPath.Path == wanted.HostPath.Path &&
+			ptr.Deref(existing.HostPath.Type, "") == ptr.Deref(wanted.HostPath.Type, "")
+	case wanted.ConfigMap != nil:
+		return existing.ConfigMap != nil && existing.ConfigMap.Name == wanted.ConfigMap.Name &&
+			ptr.Deref(existing.ConfigMap.Optional, false) == ptr.Deref(wanted.ConfigMap.Optional, false)
+	default:
+		return false
+	}
+}
+
+// Returns true if the reconciled volumes and mounts of the Onload Device
+// Plugin match those wanted.
+func devicePluginVolumesMatch(
+	volumes []corev1.Volume, mounts []corev1.VolumeMount,
+	wantedVolumes []corev1.Volume, wantedMounts []corev1.VolumeMount,
+) bool {
+	volumes = slices.DeleteFunc(slices.Clone(volumes), func(volume corev1.Volume) bool {
+		return !isReconciledVolume(volume.Name)
+	})
+	mounts = slices.DeleteFunc(slices.Clone(mounts), func(mount corev1.VolumeMount) bool {
+		return !isReconciledVolume(mount.Name)
+	})
+	if len(volumes) != len(wantedVolumes) || len(mounts) != len(wantedMounts) {
+		return false
+	}
+
+	for _, wanted := range wantedVolumes {
+		if !slices.ContainsFunc(volumes, func(volume corev1.Volume) bool {
+			return volume.Name == wanted.Name && volumeMatches(volume, wanted)
+		}) {
+			return false
+		}
+	}
+	for _, wanted := range wantedMounts {
+		if !slices.Contains(mounts, wanted) {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileDevicePluginVolumes brings the volumes of the Onload Device Plugin
+// DaemonSet which depend on the Onload CR, and the lifecycle managing the
+// files on the host, in line with it. As the DaemonSet is updated on delete,
+// running device plugins keep the previous volumes until their pods are
+// recreated.
+func (r *OnloadReconciler) reconcileDevicePluginVolumes(ctx context.Context, onload *onloadv1alpha1.Onload,
+) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	devicePlugin := &appsv1.DaemonSet{}
+	err := r.Get(
+		ctx,
+		types.NamespacedName{
+			Name:      onload.Name + devicePluginNameSuffix,
+			Namespace: onload.Namespace,
+		},
+		devicePlugin,
+	)
+	if err != nil {
+		log.Error(err, "Failed to get Device Plugin", "Onload", onload)
+		return nil, err
+	}
+
+	podSpec := &devicePlugin.Spec.Template.Spec
+	containerIndex := slices.IndexFunc(podSpec.Containers, func(container corev1.Container) bool {
+		return container.Name == devicePluginContainerName
+	})
+	if containerIndex < 0 {
+		return nil, fmt.Errorf("device plugin DaemonSet %s has no %s container",
+			devicePlugin.Name, devicePluginContainerName)
+	}
+	container := &podSpec.Containers[containerIndex]
+
+	wantedVolumes, wantedMounts := devicePluginVolumes(onload)
+	lifecycle := devicePluginLifecycle(onload)
+	if devicePluginVolumesMatch(podSpec.Volumes, container.VolumeMounts, wantedVolumes, wantedMounts) &&
+		reflect.DeepEqual(container.Lifecycle, lifecycle) {
+		// Nothing to be done, so return
+		return nil, nil
+	}
+
+	volumes := slices.DeleteFunc(slices.Clone(podSpec.Volumes), func(volume corev1.Volume) bool {
+		return isReconciledVolume(volume.Name)
+	})
+	mounts := slices.DeleteFunc(slices.Clone(container.VolumeMounts), func(mount corev1.VolumeMount) bool {
+		return isReconciledVolume(mount.Name)
+	})
+
+	oldDevicePlugin := devicePlugin.DeepCopy()
+	podSpec.Volumes = append(volumes, wantedVolumes...)
+	container.VolumeMounts = append(mounts, wantedMounts...)
+	container.Lifecycle = lifecycle
+	err = r.Patch(ctx, devicePlugin, client.MergeFrom(oldDevicePlugin))
+	if err != nil {
+		log.Error(err, "Failed to patch Device Plugin DaemonSet",
+			"Device Plugin", devicePlugin)
+		return nil, err
+	}
+
+	log.Info("Patched Device Plugin volumes", "Device Plugin", devicePlugin.Name)
+	return &ctrl.Result{Requeue: true}, nil
+}
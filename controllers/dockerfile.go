@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kmm "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+// Dockerfiles shipped with the operator, selected with Build.Template. They
+// track the samples in config/samples/onload/onload-module.
+//
+//go:embed dockerfiles/*.Dockerfile
+var dockerfileTemplates embed.FS
+
+const defaultSourceImage = "docker.io/onload/onload-source"
+
+// The key read by KMM from the Dockerfile ConfigMap.
+const dockerfileConfigMapKey = "dockerfile"
+
+const dockerfileComponent = "dockerfile"
+
+type dockerfileParams struct {
+	SourceImage string
+	Version     string
+}
+
+// renderDockerfile returns the contents of the Dockerfile generated from the
+// build's template.
+func renderDockerfile(onload *onloadv1alpha1.Onload, build *onloadv1alpha1.OnloadKernelBuild,
+) (string, error) {
+	tmpl, err := template.ParseFS(dockerfileTemplates, "dockerfiles/"+build.Template+".Dockerfile")
+	if err != nil {
+		return "", fmt.Errorf("unknown Dockerfile template %q: %w", build.Template, err)
+	}
+
+	params := dockerfileParams{
+		SourceImage: build.SourceImage,
+		Version:     onload.Spec.Onload.Version,
+	}
+	if params.SourceImage == "" {
+		params.SourceImage = defaultSourceImage + ":" + params.Version
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, params)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// dockerfileConfigMapName returns a name unique to the contents of the
+// Dockerfile so that a change in version or source image results in a new
+// ConfigMap, and so a new build, rather than changing the inputs of an
+// existing one.
+func dockerfileConfigMapName(onload *onloadv1alpha1.Onload, dockerfile string) string {
+	h := fnv.New32a()
+	h.Write([]byte(dockerfile))
+	return fmt.Sprintf("%s-dockerfile-%08x", onload.Name, h.Sum32())
+}
+
+// dockerfileConfigMaps returns the ConfigMaps required by the kernel mappings
+// that use a Dockerfile template, keyed by name.
+func dockerfileConfigMaps(onload *onloadv1alpha1.Onload) (map[string]*corev1.ConfigMap, error) {
+	configMaps := map[string]*corev1.ConfigMap{}
+
+	for _, kmap := range onload.Spec.Onload.KernelMappings {
+		if kmap.Build == nil || kmap.Build.Template == "" {
+			continue
+		}
+
+		dockerfile, err := renderDockerfile(onload, kmap.Build)
+		if err != nil {
+			return nil, err
+		}
+
+		name := dockerfileConfigMapName(onload, dockerfile)
+		configMaps[name] = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: onload.Namespace,
+				Labels:    baseLabels(onload.Name, onload.Namespace, dockerfileComponent),
+			},
+			Data: map[string]string{dockerfileConfigMapKey: dockerfile},
+		}
+	}
+
+	return configMaps, nil
+}
+
+// moduleKernelMappings converts the Onload CR's kernel mappings with
+// getKernelMap, pointing builds that use a template at the generated
+// Dockerfile ConfigMap.
+func moduleKernelMappings(onload *onloadv1alpha1.Onload, getKernelMap kernelMapperFn,
+) ([]kmm.KernelMapping, error) {
+	kernelMappings := []kmm.KernelMapping{}
+
+	for _, kmapSpec := range onload.Spec.Onload.KernelMappings {
+		kmap := getKernelMap(kmapSpec)
+
+		// We may not need to create a new Module kind for this mapping,
+		// e.g. if this is SFC and the user has deployed their kernel
+		// module managed outside the controller.
+		if kmap == nil {
+			continue
+		}
+
+		if kmap.Build != nil && kmapSpec.Build != nil && kmapSpec.Build.Template != "" {
+			dockerfile, err := renderDockerfile(onload, kmapSpec.Build)
+			if err != nil {
+				return nil, err
+			}
+			kmap.Build.DockerfileConfigMap = &corev1.LocalObjectReference{
+				Name: dockerfileConfigMapName(onload, dockerfile),
+			}
+		}
+
+		kernelMappings = append(kernelMappings, *kmap)
+	}
+
+	return kernelMappings, nil
+}
+
+// createDockerfiles creates the ConfigMaps holding the Dockerfiles generated
+// from templates. Those no longer used are deleted later by pruneDockerfiles,
+// once the Module refers to the new ones. Nothing is created for a module
+// loader that doesn't build the kernel modules.
+func (r *OnloadReconciler) createDockerfiles(ctx context.Context, onload *onloadv1alpha1.Onload,
+) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if !r.moduleLoader().SupportsBuilding() {
+		return nil, nil
+	}
+
+	configMaps, err := dockerfileConfigMaps(onload)
+	if err != nil {
+		log.Error(err, "Failed to generate Dockerfiles")
+		return nil, err
+	}
+
+	var res *ctrl.Result
+
+	for name, configMap := range configMaps {
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: onload.Namespace},
+			&corev1.ConfigMap{})
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to get Dockerfile ConfigMap", "ConfigMap", name)
+			return nil, err
+		}
+
+		err = ctrl.SetControllerReference(onload, configMap, r.Scheme)
+		if err != nil {
+			log.Error(err, "Failed to set owner of Dockerfile ConfigMap")
+			return nil, err
+		}
+
+		err = r.Create(ctx, configMap)
+		if err != nil {
+			log.Error(err, "Failed to create Dockerfile ConfigMap", "ConfigMap", name)
+			return nil, err
+		}
+
+		log.Info("Created Dockerfile ConfigMap", "ConfigMap", name)
+		res = &ctrl.Result{Requeue: true}
+	}
+
+	return res, nil
+}
+
+// referencedDockerfiles returns the names of the Dockerfile ConfigMaps that the
+// KMM Module still builds with. KMM may not be installed if the native module
+// loader is used, in which case there are none.
+func (r *OnloadReconciler) referencedDockerfiles(ctx context.Context, onload *onloadv1alpha1.Onload,
+) (map[string]bool, error) {
+	module := kmm.Module{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      onload.Name + onloadModuleNameSuffix,
+		Namespace: onload.Namespace,
+	}, &module)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return map[string]bool{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string]bool{}
+	for _, kmap := range module.Spec.ModuleLoader.Container.KernelMappings {
+		if kmap.Build != nil && kmap.Build.DockerfileConfigMap != nil {
+			referenced[kmap.Build.DockerfileConfigMap.Name] = true
+		}
+	}
+	return referenced, nil
+}
+
+// pruneDockerfiles deletes the generated Dockerfile ConfigMaps that are neither
+// used by the kernel mappings nor referenced by the Module. A Module only
+// stops referring to a ConfigMap when it is updated, so deleting the old
+// ConfigMaps before then would fail any build KMM starts in the meantime.
+func (r *OnloadReconciler) pruneDockerfiles(ctx context.Context, onload *onloadv1alpha1.Onload) error {
+	log := log.FromContext(ctx)
+
+	if !r.moduleLoader().SupportsBuilding() {
+		return nil
+	}
+
+	configMaps, err := dockerfileConfigMaps(onload)
+	if err != nil {
+		log.Error(err, "Failed to generate Dockerfiles")
+		return err
+	}
+
+	referenced, err := r.referencedDockerfiles(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to get Module")
+		return err
+	}
+
+	existing := corev1.ConfigMapList{}
+	err = r.List(ctx, &existing, client.InNamespace(onload.Namespace),
+		client.MatchingLabels(baseLabels(onload.Name, onload.Namespace, dockerfileComponent)))
+	if err != nil {
+		log.Error(err, "Failed to list Dockerfile ConfigMaps")
+		return err
+	}
+
+	for i := range existing.Items {
+		configMap := &existing.Items[i]
+		if _, ok := configMaps[configMap.Name]; ok || referenced[configMap.Name] {
+			continue
+		}
+
+		err = r.Delete(ctx, configMap)
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to delete Dockerfile ConfigMap", "ConfigMap", configMap.Name)
+			return err
+		}
+		log.Info("Deleted unused Dockerfile ConfigMap", "ConfigMap", configMap.Name)
+	}
+
+	return nil
+}
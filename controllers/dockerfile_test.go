@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+var _ = Describe("Testing Dockerfile templates", func() {
+	var onload *onloadv1alpha1.Onload
+
+	BeforeEach(func() {
+		onload = &onloadv1alpha1.Onload{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "name",
+				Namespace: "namespace",
+			},
+			Spec: onloadv1alpha1.Spec{
+				Onload: onloadv1alpha1.OnloadSpec{
+					KernelMappings: []onloadv1alpha1.OnloadKernelMapping{
+						{
+							KernelModuleImage: "module",
+							Regexp:            ".*",
+							Build: &onloadv1alpha1.OnloadKernelBuild{
+								Template: "dtk-ubi",
+							},
+						},
+					},
+					Version: "8.1.2.26",
+				},
+			},
+		}
+	})
+
+	DescribeTable("should render each template",
+		func(template string) {
+			build := &onloadv1alpha1.OnloadKernelBuild{Template: template}
+			dockerfile, err := renderDockerfile(onload, build)
+			Expect(err).Should(Succeed())
+			Expect(dockerfile).Should(ContainSubstring(
+				"ARG ONLOAD_SOURCE=docker.io/onload/onload-source:8.1.2.26\n"))
+			Expect(dockerfile).Should(ContainSubstring(
+				"LABEL onload.amd.com/version=\"8.1.2.26\"\n"))
+		},
+		Entry( /*It*/ "should render dtk-ubi", "dtk-ubi"),
+		Entry( /*It*/ "should render dtk-only", "dtk-only"),
+		Entry( /*It*/ "should render ubuntu", "ubuntu"),
+	)
+
+	It("should use the given source image", func() {
+		build := &onloadv1alpha1.OnloadKernelBuild{
+			Template:    "dtk-only",
+			SourceImage: "registry.example.com/onload-source:custom",
+		}
+		Expect(renderDockerfile(onload, build)).Should(ContainSubstring(
+			"ARG ONLOAD_SOURCE=registry.example.com/onload-source:custom\n"))
+	})
+
+	It("should reject unknown templates", func() {
+		build := &onloadv1alpha1.OnloadKernelBuild{Template: "unknown"}
+		_, err := renderDockerfile(onload, build)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should point the kernel mapping at the generated ConfigMap", func() {
+		configMaps, err := dockerfileConfigMaps(onload)
+		Expect(err).Should(Succeed())
+		Expect(configMaps).Should(HaveLen(1))
+
+		kmaps, err := moduleKernelMappings(onload, onloadKernelMapper)
+		Expect(err).Should(Succeed())
+		Expect(kmaps).Should(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"Build": PointTo(MatchFields(IgnoreExtras, Fields{
+				"DockerfileConfigMap": PointTo(MatchFields(IgnoreExtras, Fields{
+					"Name": BeKeyOf(configMaps),
+				})),
+			})),
+		})))
+	})
+
+	It("should share a ConfigMap between identical builds", func() {
+		onload.Spec.Onload.KernelMappings = append(onload.Spec.Onload.KernelMappings,
+			*onload.Spec.Onload.KernelMappings[0].DeepCopy())
+
+		configMaps, err := dockerfileConfigMaps(onload)
+		Expect(err).Should(Succeed())
+		Expect(configMaps).Should(HaveLen(1))
+	})
+
+	It("should use a new ConfigMap for a new version", func() {
+		before, err := dockerfileConfigMaps(onload)
+		Expect(err).Should(Succeed())
+
+		onload.Spec.Onload.Version = "8.1.3.40"
+		after, err := dockerfileConfigMaps(onload)
+		Expect(err).Should(Succeed())
+
+		for name := range after {
+			Expect(before).ShouldNot(HaveKey(name))
+		}
+	})
+
+	It("should leave user-provided ConfigMaps alone", func() {
+		onload.Spec.Onload.KernelMappings[0].Build = &onloadv1alpha1.OnloadKernelBuild{
+			DockerfileConfigMap: &corev1.LocalObjectReference{Name: "user-dockerfile"},
+		}
+
+		Expect(dockerfileConfigMaps(onload)).Should(BeEmpty())
+
+		kmaps, err := moduleKernelMappings(onload, onloadKernelMapper)
+		Expect(err).Should(Succeed())
+		Expect(kmaps[0].Build.DockerfileConfigMap.Name).Should(Equal("user-dockerfile"))
+	})
+})
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kmm "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+// ModuleLoader is a backend that is responsible for loading the out-of-tree
+// Onload (and optionally SFC) kernel modules onto the nodes selected by an
+// Onload CR.
+//
+// Whichever backend is used, the controller drives ordered upgrades through
+// the KMM-style version labels (see kmmOnloadLabelName), so a backend must
+// only load a module version onto nodes labelled with that version.
+type ModuleLoader interface {
+	// CreateModules creates the objects required to load the kernel modules
+	// used by the Onload CR. A non-nil result is returned if the cluster was
+	// modified.
+	CreateModules(ctx context.Context, onload *onloadv1alpha1.Onload) (*ctrl.Result, error)
+
+	// UpdateModules brings existing objects in line with the Onload CR during
+	// an upgrade. A non-nil result is returned if the cluster was modified.
+	UpdateModules(ctx context.Context, onload *onloadv1alpha1.Onload) (*ctrl.Result, error)
+
+	// PodLabels returns the labels of the pods that load the named module.
+	PodLabels(moduleName string) labels.Set
+
+	// OwnedObject returns the kind of object created by the backend that the
+	// controller should watch, or nil if no additional watch is required.
+	OwnedObject() client.Object
+
+	// SupportsSigning returns true if the backend signs the kernel modules as
+	// requested by the kernel mappings.
+	SupportsSigning() bool
+
+	// SupportsBuilding returns true if the backend builds the kernel modules
+	// in-cluster from the Dockerfiles requested by the kernel mappings.
+	SupportsBuilding() bool
+}
+
+const (
+	// ModuleLoaderKMM uses the Kernel Module Management operator.
+	ModuleLoaderKMM = "kmm"
+	// ModuleLoaderNative uses DaemonSets managed by the Onload Operator.
+	ModuleLoaderNative = "native"
+)
+
+// NewModuleLoader returns the ModuleLoader backend with the given name.
+func NewModuleLoader(name string, c client.Client, scheme *runtime.Scheme) (ModuleLoader, error) {
+	switch name {
+	case ModuleLoaderKMM:
+		return &kmmModuleLoader{Client: c, Scheme: scheme}, nil
+	case ModuleLoaderNative:
+		return &nativeModuleLoader{Client: c, Scheme: scheme}, nil
+	default:
+		return nil, fmt.Errorf("unknown module loader %q", name)
+	}
+}
+
+// kmmModuleLoader delegates loading of the kernel modules to the Kernel Module
+// Management operator by creating a KMM Module per kernel module.
+type kmmModuleLoader struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+func (l *kmmModuleLoader) PodLabels(moduleName string) labels.Set {
+	return labels.Set{"kmm.node.kubernetes.io/module.name": moduleName}
+}
+
+func (l *kmmModuleLoader) OwnedObject() client.Object {
+	return &kmm.Module{}
+}
+
+func (l *kmmModuleLoader) SupportsSigning() bool {
+	return true
+}
+
+func (l *kmmModuleLoader) SupportsBuilding() bool {
+	return true
+}
+
+func (l *kmmModuleLoader) CreateModules(
+	ctx context.Context,
+	onload *onloadv1alpha1.Onload,
+) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	createAndAddModule := func(moduleName string,
+		modprobeArg string, inTreeModuleToRemove string, getKernelMap kernelMapperFn,
+	) (*ctrl.Result, error) {
+		module := &kmm.Module{}
+		err := l.Get(
+			ctx,
+			types.NamespacedName{
+				Name:      moduleName,
+				Namespace: onload.Namespace,
+			},
+			module,
+		)
+		if err == nil {
+			return nil, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to get Module", "Module", moduleName)
+			return nil, err
+		}
+
+		module, err = createModule(onload, moduleName,
+			modprobeArg, inTreeModuleToRemove, getKernelMap)
+		if err != nil {
+			log.Error(err, "createModule failure")
+			return nil, err
+		}
+
+		err = ctrl.SetControllerReference(onload, module, l.Scheme)
+		if err != nil {
+			log.Error(err, "Failed to set owner of newly created Module")
+			return nil, err
+		}
+
+		err = l.Create(ctx, module)
+		if err != nil {
+			log.Error(err, "Failed to create new Module")
+			return nil, err
+		}
+
+		return &ctrl.Result{Requeue: true}, nil
+	}
+
+	res, err := createAndAddModule(onload.Name+onloadModuleNameSuffix, "onload", "", onloadKernelMapper)
+	if res != nil || err != nil {
+		return res, err
+	}
+
+	if onloadUsesSFC(onload) {
+		return createAndAddModule(onload.Name+sfcModuleNameSuffix, "sfc", "sfc", sfcKernelMapper)
+	}
+
+	return nil, nil
+}
+
+func (l *kmmModuleLoader) patchModule(ctx context.Context, module *kmm.Module,
+	onload *onloadv1alpha1.Onload, getKernelMap kernelMapperFn,
+) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// The image repo secret can be rotated without an upgrade.
+	versionChanged := module.Spec.ModuleLoader.Container.Version != onload.Spec.Onload.Version
+	secretChanged := ptr.Deref(module.Spec.ImageRepoSecret, corev1.LocalObjectReference{}) !=
+		ptr.Deref(onload.Spec.Onload.ImageRepoSecret, corev1.LocalObjectReference{})
+	if !versionChanged && !secretChanged {
+		// Nothing to be done, so return
+		return nil, nil
+	}
+
+	oldModule := module.DeepCopy()
+	module.Spec.ImageRepoSecret = onload.Spec.Onload.ImageRepoSecret
+	if versionChanged {
+		kernelMappings, err := moduleKernelMappings(onload, getKernelMap)
+		if err != nil {
+			log.Error(err, "Failed to convert kernel mappings")
+			return nil, err
+		}
+		module.Spec.ModuleLoader.Container.Version = onload.Spec.Onload.Version
+		module.Spec.ModuleLoader.Container.KernelMappings = kernelMappings
+	}
+
+	err := l.Patch(ctx, module, client.MergeFrom(oldModule))
+	if err != nil {
+		log.Error(err, "Failed to patch Module", "Module", module)
+		return nil, err
+	}
+
+	if versionChanged {
+		log.Info("Updated Module definition for upgrade", "Module", module)
+	} else {
+		log.Info("Updated Module image repo secret", "Module", module.Name)
+	}
+	return &ctrl.Result{Requeue: true}, nil
+}
+
+func (l *kmmModuleLoader) UpdateModules(ctx context.Context, onload *onloadv1alpha1.Onload) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	patchOrDeleteModule := func(isUsed bool, moduleName string, getKernelMap kernelMapperFn,
+	) (*ctrl.Result, error) {
+		module := &kmm.Module{}
+		err := l.Get(ctx, types.NamespacedName{Name: moduleName, Namespace: onload.Namespace}, module)
+
+		//
+		// Below are possible module kind-related actions.
+		//
+		// +------------------------+--------+----------+
+		// | Exists \ Should exist? |  Yes   |    No    |
+		// +------------------------+--------+----------+
+		// | Yes                    | Patch  | Delete   |
+		// | No                     | Error* | Continue |
+		// +------------------------+--------+----------+
+		//
+		// (*) Error because the reconciliation loop must check the existence
+		// of the SFC module kind before it reaches this module update code.
+		//
+		if err == nil { // Module kind exists
+			if isUsed {
+				return l.patchModule(ctx, module, onload, getKernelMap)
+			} else {
+				return &ctrl.Result{Requeue: true}, l.Delete(ctx, module)
+			}
+		} else if apierrors.IsNotFound(err) {
+			if isUsed {
+				err := fmt.Errorf("module %s should exist", moduleName)
+				return nil, err
+			} else {
+				return nil, nil
+			}
+		} else {
+			log.Error(err, "Failed to get Module", "Module", moduleName)
+			return nil, err
+		}
+	}
+
+	// Onload module kind is always used for Onload CR, hence "true".
+	res, err := patchOrDeleteModule(true, onload.Name+onloadModuleNameSuffix, onloadKernelMapper)
+	if res != nil || err != nil {
+		return res, err
+	}
+
+	return patchOrDeleteModule(onloadUsesSFC(onload), onload.Name+sfcModuleNameSuffix, sfcKernelMapper)
+}
@@ -0,0 +1,452 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kmm "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+// kernelVersionLabel is set on nodes by the native module loader so that the
+// module loader DaemonSets can select the nodes running a given kernel.
+const kernelVersionLabel = onloadLabelPrefix + "kernel-version.full"
+
+// moduleNameLabel is set on the module loader pods.
+const moduleNameLabel = onloadLabelPrefix + "module.name"
+
+// Location of the kernel modules inside a KernelModuleImage, matching the
+// default used by KMM.
+const moduleImageDirName = "/opt"
+
+// nativeModuleLoader loads the kernel modules without depending on the Kernel
+// Module Management operator. For each kernel module it runs a privileged
+// DaemonSet per kernel version and module version, much like KMM does. The
+// pods `modprobe` the module from the KernelModuleImage when they start and
+// remove it again when they are stopped.
+//
+// In-cluster builds and module signing are not supported by this backend; the
+// images referenced by the kernel mappings must already exist.
+type nativeModuleLoader struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// nativeModule describes one of the kernel modules managed by the loader.
+type nativeModule struct {
+	name                 string
+	modprobeArg          string
+	inTreeModuleToRemove string
+	versionLabel         string
+	getKernelMap         kernelMapperFn
+}
+
+func nativeModules(onload *onloadv1alpha1.Onload) []nativeModule {
+	modules := []nativeModule{
+		{
+			name:         onload.Name + onloadModuleNameSuffix,
+			modprobeArg:  "onload",
+			versionLabel: kmmOnloadLabelName(onload.Name, onload.Namespace),
+			getKernelMap: onloadKernelMapper,
+		},
+	}
+	if onloadUsesSFC(onload) {
+		modules = append(modules, nativeModule{
+			name:                 onload.Name + sfcModuleNameSuffix,
+			modprobeArg:          "sfc",
+			inTreeModuleToRemove: "sfc",
+			versionLabel:         kmmSFCLabelName(onload.Name, onload.Namespace),
+			getKernelMap:         sfcKernelMapper,
+		})
+	}
+	return modules
+}
+
+func (l *nativeModuleLoader) PodLabels(moduleName string) labels.Set {
+	return labels.Set{moduleNameLabel: moduleName}
+}
+
+func (l *nativeModuleLoader) OwnedObject() client.Object {
+	// DaemonSets are already owned by the controller for the device plugin.
+	return nil
+}
+
+func (l *nativeModuleLoader) SupportsSigning() bool {
+	return false
+}
+
+func (l *nativeModuleLoader) SupportsBuilding() bool {
+	return false
+}
+
+// findKernelMapping returns the first kernel mapping that matches the kernel
+// version, or nil if none do.
+func findKernelMapping(kmaps []kmm.KernelMapping, kernelVersion string) (*kmm.KernelMapping, error) {
+	for i := range kmaps {
+		matched, err := regexp.MatchString(kmaps[i].Regexp, kernelVersion)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return &kmaps[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// substituteKernelVersion expands the kernel variables supported by KMM in a
+// container image reference.
+func substituteKernelVersion(image string, kernelVersion string) string {
+	fields := regexp.MustCompile("[.,-]").Split(kernelVersion, -1)
+	field := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+	vars := map[string]string{
+		"KERNEL_FULL_VERSION": kernelVersion,
+		"KERNEL_VERSION":      kernelVersion,
+		"KERNEL_XYZ":          strings.Join([]string{field(0), field(1), field(2)}, "."),
+		"KERNEL_X":            field(0),
+		"KERNEL_Y":            field(1),
+		"KERNEL_Z":            field(2),
+	}
+	return os.Expand(image, func(name string) string { return vars[name] })
+}
+
+func nativeLoaderName(moduleName, kernelVersion, version string) string {
+	h := fnv.New32a()
+	h.Write([]byte(kernelVersion + "/" + version))
+	return fmt.Sprintf("%s-%08x", moduleName, h.Sum32())
+}
+
+// nativeLoaderDaemonSet returns the DaemonSet that loads the module for a
+// single kernel version.
+func nativeLoaderDaemonSet(onload *onloadv1alpha1.Onload, module nativeModule,
+	kmap *kmm.KernelMapping, kernelVersion string,
+) *appsv1.DaemonSet {
+	version := onload.Spec.Onload.Version
+
+	dsLabels := baseLabels(onload.Name, onload.Namespace, "module-loader")
+	dsLabels[moduleNameLabel] = module.name
+
+	podLabels := labels.Set{
+		moduleNameLabel:     module.name,
+		kernelVersionLabel:  kernelVersion,
+		module.versionLabel: version,
+	}
+
+	nodeSelector := map[string]string{}
+	for k, v := range onload.Spec.Selector {
+		nodeSelector[k] = v
+	}
+	nodeSelector[kernelVersionLabel] = kernelVersion
+	nodeSelector[module.versionLabel] = version
+
+	// Loading the module must succeed whether or not it is already loaded, as
+	// the hook runs again whenever the container restarts. For the same
+	// reason the in-tree module may be absent, or may already have been
+	// replaced by one that is in use, so failing to remove it isn't fatal.
+	modprobe := "modprobe -d " + moduleImageDirName
+	load := fmt.Sprintf("%s -v %s", modprobe, module.modprobeArg)
+	if module.inTreeModuleToRemove != "" {
+		load = fmt.Sprintf("if [ -e /sys/module/%s ]; then %s -r %s || true; fi; %s",
+			module.inTreeModuleToRemove, modprobe, module.inTreeModuleToRemove, load)
+	}
+	unload := fmt.Sprintf("%s -rv %s", modprobe, module.modprobeArg)
+
+	nodeLibModulesPath := "/lib/modules/" + kernelVersion
+
+	container := corev1.Container{
+		Name:            "module-loader",
+		Image:           substituteKernelVersion(kmap.ContainerImage, kernelVersion),
+		ImagePullPolicy: onload.Spec.Onload.ImagePullPolicy,
+		Command:         []string{"sleep", "infinity"},
+		Lifecycle: &corev1.Lifecycle{
+			PostStart: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", load}},
+			},
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", unload}},
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			Privileged: ptr.To(true),
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "node-lib-modules",
+				MountPath: nodeLibModulesPath,
+				ReadOnly:  true,
+			},
+		},
+	}
+
+	pullSecrets := []corev1.LocalObjectReference{}
+	if onload.Spec.Onload.ImageRepoSecret != nil {
+		pullSecrets = append(pullSecrets, *onload.Spec.Onload.ImageRepoSecret)
+	}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nativeLoaderName(module.name, kernelVersion, version),
+			Namespace: onload.Namespace,
+			Labels:    dsLabels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: podLabels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: onload.Spec.ServiceAccountName,
+					PriorityClassName:  "system-node-critical",
+					Containers:         []corev1.Container{container},
+					NodeSelector:       nodeSelector,
+					ImagePullSecrets:   pullSecrets,
+					Volumes: []corev1.Volume{
+						{
+							Name: "node-lib-modules",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: nodeLibModulesPath,
+									Type: ptr.To(corev1.HostPathDirectory),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// labelKernelVersions labels each node with its kernel version so that it can
+// be selected by the module loader DaemonSets.
+func (l *nativeModuleLoader) labelKernelVersions(ctx context.Context, nodes []corev1.Node) (bool, error) {
+	log := log.FromContext(ctx)
+
+	changesMade := false
+	for _, node := range nodes {
+		kernelVersion := node.Status.NodeInfo.KernelVersion
+		if kernelVersion == "" || node.Labels[kernelVersionLabel] == kernelVersion {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(kernelVersion); len(errs) > 0 {
+			log.Info("Kernel version is not a valid label value, skipping Node",
+				"Node", node.Name, "kernel", kernelVersion, "errors", errs)
+			continue
+		}
+
+		nodeCopy := node.DeepCopy()
+		node.Labels[kernelVersionLabel] = kernelVersion
+		err := l.Patch(ctx, &node, client.MergeFrom(nodeCopy))
+		if err != nil {
+			log.Error(err, "Failed to patch Node with kernel version label",
+				"Node", node.Name)
+			return false, err
+		}
+		changesMade = true
+	}
+	return changesMade, nil
+}
+
+// patchPullSecrets patches the image pull secrets of the module loader
+// DaemonSet if they have changed, returning true if they had.
+func (l *nativeModuleLoader) patchPullSecrets(ctx context.Context, ds *appsv1.DaemonSet,
+	pullSecrets []corev1.LocalObjectReference,
+) (bool, error) {
+	log := log.FromContext(ctx)
+
+	if slices.Equal(ds.Spec.Template.Spec.ImagePullSecrets, pullSecrets) {
+		return false, nil
+	}
+
+	oldDS := ds.DeepCopy()
+	ds.Spec.Template.Spec.ImagePullSecrets = pullSecrets
+	err := l.Patch(ctx, ds, client.MergeFrom(oldDS))
+	if err != nil {
+		log.Error(err, "Failed to patch module loader DaemonSet", "DaemonSet", ds.Name)
+		return false, err
+	}
+	log.Info("Updated module loader DaemonSet image pull secrets", "DaemonSet", ds.Name)
+	return true, nil
+}
+
+func (l *nativeModuleLoader) listNodes(ctx context.Context, onload *onloadv1alpha1.Onload) ([]corev1.Node, error) {
+	nodes := corev1.NodeList{}
+	err := l.List(ctx, &nodes, client.MatchingLabels(onload.Spec.Selector))
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+func (l *nativeModuleLoader) CreateModules(
+	ctx context.Context,
+	onload *onloadv1alpha1.Onload,
+) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	nodes, err := l.listNodes(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to list Nodes")
+		return nil, err
+	}
+
+	changesMade, err := l.labelKernelVersions(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+	if changesMade {
+		log.Info("Labelled Nodes with kernel version label")
+		return &ctrl.Result{Requeue: true}, nil
+	}
+
+	kernelVersions := map[string]bool{}
+	for _, node := range nodes {
+		if kernelVersion, found := node.Labels[kernelVersionLabel]; found {
+			kernelVersions[kernelVersion] = true
+		}
+	}
+
+	for _, module := range nativeModules(onload) {
+		kmaps := []kmm.KernelMapping{}
+		for _, kmapSpec := range onload.Spec.Onload.KernelMappings {
+			if kmap := module.getKernelMap(kmapSpec); kmap != nil {
+				kmaps = append(kmaps, *kmap)
+			}
+		}
+
+		for kernelVersion := range kernelVersions {
+			kmap, err := findKernelMapping(kmaps, kernelVersion)
+			if err != nil {
+				log.Error(err, "Failed to match kernel mappings", "kernel", kernelVersion)
+				return nil, err
+			}
+			if kmap == nil {
+				log.Info("No kernel mapping matches kernel", "kernel", kernelVersion,
+					"Module", module.name)
+				continue
+			}
+
+			ds := nativeLoaderDaemonSet(onload, module, kmap, kernelVersion)
+			existing := &appsv1.DaemonSet{}
+			err = l.Get(ctx, client.ObjectKeyFromObject(ds), existing)
+			if err == nil {
+				// The image repo secret can be rotated without an upgrade.
+				patched, err := l.patchPullSecrets(ctx, existing, ds.Spec.Template.Spec.ImagePullSecrets)
+				if err != nil {
+					return nil, err
+				}
+				changesMade = changesMade || patched
+				continue
+			}
+			if client.IgnoreNotFound(err) != nil {
+				log.Error(err, "Failed to get module loader DaemonSet", "DaemonSet", ds.Name)
+				return nil, err
+			}
+
+			err = ctrl.SetControllerReference(onload, ds, l.Scheme)
+			if err != nil {
+				log.Error(err, "Failed to set owner of module loader DaemonSet")
+				return nil, err
+			}
+
+			err = l.Create(ctx, ds)
+			if err != nil {
+				log.Error(err, "Failed to create module loader DaemonSet")
+				return nil, err
+			}
+			changesMade = true
+		}
+	}
+
+	if changesMade {
+		return &ctrl.Result{Requeue: true}, nil
+	}
+	return nil, nil
+}
+
+// UpdateModules deletes module loader DaemonSets that are no longer needed.
+// DaemonSets for a previous version of the module are kept until no node is
+// labelled with that version any more, so that the module remains loaded on
+// nodes that have yet to be upgraded.
+func (l *nativeModuleLoader) UpdateModules(ctx context.Context, onload *onloadv1alpha1.Onload) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	nodes, err := l.listNodes(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to list Nodes")
+		return nil, err
+	}
+
+	modules := map[string]nativeModule{}
+	for _, module := range nativeModules(onload) {
+		modules[module.name] = module
+	}
+
+	daemonSets := appsv1.DaemonSetList{}
+	err = l.List(ctx, &daemonSets, client.InNamespace(onload.Namespace),
+		client.MatchingLabels(baseLabels(onload.Name, onload.Namespace, "module-loader")))
+	if err != nil {
+		log.Error(err, "Failed to list module loader DaemonSets")
+		return nil, err
+	}
+
+	changesMade := false
+	for _, ds := range daemonSets.Items {
+		module, used := modules[ds.Labels[moduleNameLabel]]
+
+		inUse := false
+		if used {
+			nodeSelector := ds.Spec.Template.Spec.NodeSelector
+			inUse = slices.ContainsFunc(nodes, func(node corev1.Node) bool {
+				if node.Labels[kernelVersionLabel] != nodeSelector[kernelVersionLabel] {
+					return false
+				}
+				if nodeSelector[module.versionLabel] == onload.Spec.Onload.Version {
+					return true
+				}
+				return node.Labels[module.versionLabel] == nodeSelector[module.versionLabel]
+			})
+		}
+		if inUse {
+			continue
+		}
+
+		err := l.Delete(ctx, &ds)
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to delete module loader DaemonSet", "DaemonSet", ds.Name)
+			return nil, err
+		}
+		log.Info("Deleted unused module loader DaemonSet", "DaemonSet", ds.Name)
+		changesMade = true
+	}
+
+	if changesMade {
+		return &ctrl.Result{Requeue: true}, nil
+	}
+	return nil, nil
+}
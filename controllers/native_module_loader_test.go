@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kmm "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+var _ = Describe("Testing the native module loader", func() {
+	var onload *onloadv1alpha1.Onload
+
+	BeforeEach(func() {
+		onload = &onloadv1alpha1.Onload{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "name",
+				Namespace: "namespace",
+			},
+			Spec: onloadv1alpha1.Spec{
+				Selector: map[string]string{
+					"key": "value",
+				},
+				Onload: onloadv1alpha1.OnloadSpec{
+					KernelMappings: []onloadv1alpha1.OnloadKernelMapping{
+						{
+							KernelModuleImage: "module:${KERNEL_FULL_VERSION}",
+							Regexp:            "^5\\.14.*$",
+						},
+					},
+					Version: "foo",
+				},
+			},
+		}
+	})
+
+	It("should use the first matching kernel mapping", func() {
+		kmaps := []kmm.KernelMapping{
+			{Regexp: "^4\\..*$", ContainerImage: "a"},
+			{Regexp: "^5\\..*$", ContainerImage: "b"},
+			{Regexp: ".*", ContainerImage: "c"},
+		}
+
+		Expect(findKernelMapping(kmaps, "5.14.0-284.el9.x86_64")).Should(PointTo(
+			MatchFields(IgnoreExtras, Fields{"ContainerImage": Equal("b")}),
+		))
+		Expect(findKernelMapping(kmaps[:2], "6.1.0")).Should(BeNil())
+	})
+
+	DescribeTable("should substitute kernel versions into image names",
+		func(image string, expected string) {
+			Expect(substituteKernelVersion(image, "5.14.0-284.el9.x86_64")).
+				Should(Equal(expected))
+		},
+		Entry( /*It*/ "should leave literal images alone", "image:tag", "image:tag"),
+		Entry( /*It*/ "should expand the full version",
+			"image:${KERNEL_FULL_VERSION}", "image:5.14.0-284.el9.x86_64"),
+		Entry( /*It*/ "should expand the version components",
+			"image:${KERNEL_XYZ}-$KERNEL_X", "image:5.14.0-5"),
+	)
+
+	It("should select nodes by kernel and module version", func() {
+		module := nativeModules(onload)[0]
+		kmap := onloadKernelMapper(onload.Spec.Onload.KernelMappings[0])
+		kernel := "5.14.0-284.el9.x86_64"
+
+		ds := nativeLoaderDaemonSet(onload, module, kmap, kernel)
+
+		Expect(ds.Spec.Template.Spec.NodeSelector).Should(Equal(map[string]string{
+			"key":                                   "value",
+			kernelVersionLabel:                      kernel,
+			kmmOnloadLabelName("name", "namespace"): "foo",
+		}))
+		Expect(ds.Spec.Template.Labels).Should(HaveKeyWithValue(moduleNameLabel, "name-module"))
+		Expect(ds.Spec.Template.Spec.Containers).Should(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"Image": Equal("module:" + kernel),
+		})))
+	})
+
+	It("should pull the module image with the image repo secret", func() {
+		module := nativeModules(onload)[0]
+		kmap := onloadKernelMapper(onload.Spec.Onload.KernelMappings[0])
+
+		ds := nativeLoaderDaemonSet(onload, module, kmap, "5.14.0")
+		Expect(ds.Spec.Template.Spec.ImagePullSecrets).Should(BeEmpty())
+
+		onload.Spec.Onload.ImageRepoSecret = &corev1.LocalObjectReference{Name: "secret"}
+		ds = nativeLoaderDaemonSet(onload, module, kmap, "5.14.0")
+		Expect(ds.Spec.Template.Spec.ImagePullSecrets).Should(ConsistOf(
+			corev1.LocalObjectReference{Name: "secret"},
+		))
+	})
+
+	It("should load the module even if it is already loaded", func() {
+		module := nativeModules(onload)[0]
+		kmap := onloadKernelMapper(onload.Spec.Onload.KernelMappings[0])
+
+		ds := nativeLoaderDaemonSet(onload, module, kmap, "5.14.0")
+		postStart := ds.Spec.Template.Spec.Containers[0].Lifecycle.PostStart.Exec.Command
+		Expect(postStart).Should(Equal([]string{"/bin/sh", "-c", "modprobe -d /opt -v onload"}))
+	})
+
+	It("should only remove the in-tree module if it is loaded", func() {
+		onload.Spec.Onload.KernelMappings[0].SFC = &onloadv1alpha1.SFCSpec{}
+		module := nativeModules(onload)[1]
+		kmap := sfcKernelMapper(onload.Spec.Onload.KernelMappings[0])
+
+		ds := nativeLoaderDaemonSet(onload, module, kmap, "5.14.0")
+		postStart := ds.Spec.Template.Spec.Containers[0].Lifecycle.PostStart.Exec.Command
+		Expect(postStart).Should(Equal([]string{"/bin/sh", "-c",
+			"if [ -e /sys/module/sfc ]; then modprobe -d /opt -r sfc || true; fi; " +
+				"modprobe -d /opt -v sfc"}))
+	})
+
+	It("should give each module version its own DaemonSet", func() {
+		module := nativeModules(onload)[0]
+		kmap := onloadKernelMapper(onload.Spec.Onload.KernelMappings[0])
+
+		before := nativeLoaderDaemonSet(onload, module, kmap, "5.14.0")
+		onload.Spec.Onload.Version = "bar"
+		after := nativeLoaderDaemonSet(onload, module, kmap, "5.14.0")
+
+		Expect(before.Name).ShouldNot(Equal(after.Name))
+	})
+
+	It("should manage the SFC module only when requested", func() {
+		Expect(nativeModules(onload)).Should(HaveLen(1))
+
+		onload.Spec.Onload.KernelMappings[0].SFC = &onloadv1alpha1.SFCSpec{}
+		modules := nativeModules(onload)
+		Expect(modules).Should(HaveLen(2))
+		Expect(modules[1].modprobeArg).Should(Equal("sfc"))
+		Expect(modules[1].inTreeModuleToRemove).Should(Equal("sfc"))
+	})
+})
@@ -5,6 +5,7 @@ package controllers
 import (
 	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
 	"slices"
 	"strings"
@@ -40,6 +41,17 @@ type OnloadReconciler struct {
 	client.Client
 	Scheme            *runtime.Scheme
 	DevicePluginImage string
+
+	// ModuleLoader is the backend used to load the kernel modules onto the
+	// nodes. If nil, the Kernel Module Management operator is used.
+	ModuleLoader ModuleLoader
+}
+
+func (r *OnloadReconciler) moduleLoader() ModuleLoader {
+	if r.ModuleLoader == nil {
+		return &kmmModuleLoader{Client: r.Client, Scheme: r.Scheme}
+	}
+	return r.ModuleLoader
 }
 
 //+kubebuilder:rbac:groups=onload.amd.com,resources=onloads,verbs=get;list;watch;create;update;patch;delete
@@ -47,9 +59,13 @@ type OnloadReconciler struct {
 //+kubebuilder:rbac:groups=kmm.sigs.x-k8s.io,resources=modules,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=onload.amd.com,resources=onloads/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="core",resources=configmaps,verbs=get;list;watch;create;update;delete
 //+kubebuilder:rbac:groups="core",resources=nodes,verbs=get;list;watch;patch
 //+kubebuilder:rbac:groups="core",resources=pods,verbs=get;list;watch;patch;delete
 //+kubebuilder:rbac:groups="core",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups="core",resources=services,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -103,6 +119,12 @@ func (r *OnloadReconciler) Reconcile(
 			fmt.Errorf("Combined length of Onload name and namespace is too long")
 	}
 
+	err = r.updateKernelMappingStatus(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to update kernel mapping status")
+		return ctrl.Result{}, err
+	}
+
 	res, err := r.addKmmLabelsToNodes(ctx, onload)
 	if err != nil {
 		log.Error(err, "Failed to add kmm label to Nodes")
@@ -112,7 +134,16 @@ func (r *OnloadReconciler) Reconcile(
 		return *res, nil
 	}
 
-	res, err = r.createAndAddModules(ctx, onload)
+	res, err = r.createDockerfiles(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to create Dockerfile ConfigMaps")
+		return ctrl.Result{}, err
+	} else if res != nil {
+		// Logging is handled in createDockerfiles
+		return *res, nil
+	}
+
+	res, err = r.moduleLoader().CreateModules(ctx, onload)
 	if err != nil {
 		log.Info("Error creating module")
 		return ctrl.Result{}, err
@@ -121,6 +152,12 @@ func (r *OnloadReconciler) Reconcile(
 		return *res, nil
 	}
 
+	err = r.updateSigningStatus(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to update module signing status")
+		return ctrl.Result{}, err
+	}
+
 	res, err = r.addOnloadLabelsToNodes(ctx, onload)
 	if err != nil {
 		log.Error(err, "Failed to add Onload label to nodes")
@@ -130,6 +167,24 @@ func (r *OnloadReconciler) Reconcile(
 		return *res, nil
 	}
 
+	res, err = r.reconcileDevicePluginConfig(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to reconcile Device Plugin config")
+		return ctrl.Result{}, err
+	} else if res != nil {
+		// Logging is handled in reconcileDevicePluginConfig
+		return *res, nil
+	}
+
+	res, err = r.reconcileDevicePluginMetrics(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to reconcile Device Plugin metrics")
+		return ctrl.Result{}, err
+	} else if res != nil {
+		// Logging is handled in reconcileDevicePluginMetrics
+		return *res, nil
+	}
+
 	res, err = r.createDevicePluginDaemonSet(ctx, onload)
 	if err != nil {
 		log.Error(err, "Failed to create Device Plugin daemonset")
@@ -139,6 +194,15 @@ func (r *OnloadReconciler) Reconcile(
 		return *res, nil
 	}
 
+	res, err = r.reconcileDevicePluginVolumes(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to reconcile Device Plugin volumes")
+		return ctrl.Result{}, err
+	} else if res != nil {
+		// Logging is handled in reconcileDevicePluginVolumes
+		return *res, nil
+	}
+
 	res, err = r.handleUpdate(ctx, onload)
 	if err != nil {
 		log.Error(err, "Failed to handle updates")
@@ -149,6 +213,12 @@ func (r *OnloadReconciler) Reconcile(
 		return *res, nil
 	}
 
+	err = r.pruneDockerfiles(ctx, onload)
+	if err != nil {
+		log.Error(err, "Failed to delete unused Dockerfile ConfigMaps")
+		return ctrl.Result{}, err
+	}
+
 	return ctrl.Result{}, nil
 }
 
@@ -200,6 +270,20 @@ func (r *OnloadReconciler) deleteLabelFromNode(ctx context.Context, node corev1.
 	return r.Patch(ctx, &node, client.MergeFrom(nodeCopy))
 }
 
+// Returns true if the node has any of the labels the Onload CR adds to it.
+func nodeHasOnloadLabels(onload *onloadv1alpha1.Onload, node corev1.Node) bool {
+	for _, labelKey := range []string{
+		onloadLabelName(onload.Name, onload.Namespace),
+		kmmSFCLabelName(onload.Name, onload.Namespace),
+		kmmOnloadLabelName(onload.Name, onload.Namespace),
+	} {
+		if _, found := node.Labels[labelKey]; found {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *OnloadReconciler) deleteLabels(ctx context.Context, namespacedName types.NamespacedName) error {
 	log := log.FromContext(ctx)
 
@@ -234,6 +318,13 @@ func (r *OnloadReconciler) deleteLabels(ctx context.Context, namespacedName type
 		return err
 	}
 
+	// Added by the native module loader. Any other Onload CR using it labels
+	// the nodes again when it is next reconciled.
+	err = deleteLabel(kernelVersionLabel)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -290,10 +381,29 @@ func (r *OnloadReconciler) addKmmLabelsToNodes(ctx context.Context, onload *onlo
 	}
 
 	for _, node := range nodes.Items {
+		if onload.Spec.Onload.SkipUnmatchedNodes {
+			matches, err := kernelMappingMatches(onload.Spec.Onload.KernelMappings,
+				node.Status.NodeInfo.KernelVersion)
+			if err != nil {
+				return nil, err
+			}
+			// The node is reported in the status by updateKernelMappingStatus.
+			// It may have been labelled before its kernel or the kernel
+			// mappings changed, so take Onload off it as during an upgrade,
+			// evicting the pods using Onload before the modules are unloaded.
+			if matches != 1 {
+				if !nodeHasOnloadLabels(onload, node) {
+					continue
+				}
+				log.Info("Removing Onload from unmatched Node", "Node", node.Name)
+				return r.handleNodeUpdate(ctx, onload, node)
+			}
+		}
+
 		// Try add Onload labels
 		res, err := addKmmLabelToNode(node,
 			kmmOnloadLabelName(onload.Name, onload.Namespace),
-			labels.Set{"kmm.node.kubernetes.io/module.name": onload.Name + onloadModuleNameSuffix})
+			r.moduleLoader().PodLabels(onload.Name+onloadModuleNameSuffix))
 		if res != nil || err != nil {
 			return res, err
 		}
@@ -302,7 +412,7 @@ func (r *OnloadReconciler) addKmmLabelsToNodes(ctx context.Context, onload *onlo
 		if onloadUsesSFC(onload) {
 			res, err := addKmmLabelToNode(node,
 				kmmSFCLabelName(onload.Name, onload.Namespace),
-				labels.Set{"kmm.node.kubernetes.io/module.name": onload.Name + sfcModuleNameSuffix})
+				r.moduleLoader().PodLabels(onload.Name+sfcModuleNameSuffix))
 			if res != nil || err != nil {
 				return res, err
 			}
@@ -310,7 +420,7 @@ func (r *OnloadReconciler) addKmmLabelsToNodes(ctx context.Context, onload *onlo
 	}
 
 	if changesMade {
-		log.Info("Labelled Nodes with kmm label")
+		log.Info("Updated kmm labels on Nodes")
 		return &ctrl.Result{Requeue: true}, nil
 	}
 
@@ -439,14 +549,21 @@ func (r *OnloadReconciler) handleDevicePluginUpdate(ctx context.Context, onload
 		return nil, err
 	}
 
-	if devicePlugin.ObjectMeta.Labels[onloadVersionLabel] == onload.Spec.Onload.Version {
+	// The image pull secrets can be rotated without an upgrade.
+	versionChanged := devicePlugin.ObjectMeta.Labels[onloadVersionLabel] != onload.Spec.Onload.Version
+	secretsChanged := !slices.Equal(devicePlugin.Spec.Template.Spec.ImagePullSecrets,
+		onload.Spec.Onload.ImagePullSecrets)
+	if !versionChanged && !secretsChanged {
 		// Nothing to be done, so return
 		return nil, nil
 	}
 
 	oldDevicePlugin := devicePlugin.DeepCopy()
-	devicePlugin.ObjectMeta.Labels[onloadVersionLabel] = onload.Spec.Onload.Version
-	devicePlugin.Spec.Template.Spec.InitContainers[0].Image = onload.Spec.Onload.UserImage
+	if versionChanged {
+		devicePlugin.ObjectMeta.Labels[onloadVersionLabel] = onload.Spec.Onload.Version
+		devicePlugin.Spec.Template.Spec.InitContainers[0].Image = onload.Spec.Onload.UserImage
+	}
+	devicePlugin.Spec.Template.Spec.ImagePullSecrets = onload.Spec.Onload.ImagePullSecrets
 	err = r.Patch(ctx, devicePlugin, client.MergeFrom(oldDevicePlugin))
 	if err != nil {
 		log.Error(err, "Failed to patch Device Plugin DaemonSet",
@@ -458,97 +575,16 @@ func (r *OnloadReconciler) handleDevicePluginUpdate(ctx context.Context, onload
 	return &ctrl.Result{Requeue: true}, nil
 }
 
-func (r *OnloadReconciler) patchModule(ctx context.Context, module *kmm.Module,
-	onload *onloadv1alpha1.Onload, getKernelMap kernelMapperFn,
-) (*ctrl.Result, error) {
-	log := log.FromContext(ctx)
-
-	if module.Spec.ModuleLoader.Container.Version == onload.Spec.Onload.Version {
-		// Nothing to be done, so return
-		return nil, nil
-	}
-
-	kernelMappings := []kmm.KernelMapping{}
-
-	for _, kmapSpec := range onload.Spec.Onload.KernelMappings {
-		kmap := getKernelMap(kmapSpec)
-		if kmap == nil {
-			continue
-		}
-
-		kernelMappings = append(kernelMappings, *kmap)
-	}
-
-	oldModule := module.DeepCopy()
-	module.Spec.ModuleLoader.Container.Version = onload.Spec.Onload.Version
-	module.Spec.ModuleLoader.Container.KernelMappings = kernelMappings
-
-	err := r.Patch(ctx, module, client.MergeFrom(oldModule))
-	if err != nil {
-		log.Error(err, "Failed to patch Module", "Module", module)
-		return nil, err
-	}
-
-	log.Info("Updated Module definition for upgrade", "Module", module)
-	return &ctrl.Result{Requeue: true}, nil
-}
-
-func (r *OnloadReconciler) handleModuleUpdate(ctx context.Context, onload *onloadv1alpha1.Onload) (*ctrl.Result, error) {
-	log := log.FromContext(ctx)
-
-	patchOrDeleteModule := func(isUsed bool, moduleName string, getKernelMap kernelMapperFn,
-	) (*ctrl.Result, error) {
-		module := &kmm.Module{}
-		err := r.Get(ctx, types.NamespacedName{Name: moduleName, Namespace: onload.Namespace}, module)
-
-		//
-		// Below are possible module kind-related actions.
-		//
-		// +------------------------+--------+----------+
-		// | Exists \ Should exist? |  Yes   |    No    |
-		// +------------------------+--------+----------+
-		// | Yes                    | Patch  | Delete   |
-		// | No                     | Error* | Continue |
-		// +------------------------+--------+----------+
-		//
-		// (*) Error because the reconciliation loop must check the existence
-		// of the SFC module kind before it reaches this module update code.
-		//
-		if err == nil { // Module kind exists
-			if isUsed {
-				return r.patchModule(ctx, module, onload, getKernelMap)
-			} else {
-				return &ctrl.Result{Requeue: true}, r.Delete(ctx, module)
-			}
-		} else if apierrors.IsNotFound(err) {
-			if isUsed {
-				err := fmt.Errorf("module %s should exist", moduleName)
-				return nil, err
-			} else {
-				return nil, nil
-			}
-		} else {
-			log.Error(err, "Failed to get Module", "Module", moduleName)
-			return nil, err
-		}
-	}
-
-	// Onload module kind is always used for Onload CR, hence "true".
-	res, err := patchOrDeleteModule(true, onload.Name+onloadModuleNameSuffix, onloadKernelMapper)
-	if res != nil || err != nil {
-		return res, err
-	}
-
-	return patchOrDeleteModule(onloadUsesSFC(onload), onload.Name+sfcModuleNameSuffix, sfcKernelMapper)
-}
-
+// handleNodeUpdate takes Onload off the node, either to upgrade it or as it
+// no longer matches a kernel mapping. The Onload label is removed first, so
+// that the Device Plugin pod stops, then the pods using Onload are evicted,
+// and finally the kmm labels are removed so that the modules are unloaded.
 func (r *OnloadReconciler) handleNodeUpdate(ctx context.Context, onload *onloadv1alpha1.Onload, node corev1.Node) (*ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
 	// Remove the onload label from the node
 	onloadLabelName := onloadLabelName(onload.Name, onload.Namespace)
-	onloadLabelVersion, found := node.Labels[onloadLabelName]
-	if found && onloadLabelVersion != onload.Spec.Onload.Version {
+	if _, found := node.Labels[onloadLabelName]; found {
 		err := r.deleteLabelFromNode(ctx, node, onloadLabelName)
 		if err != nil {
 			log.Error(err, "Could not patch Node to remove Onload label",
@@ -575,7 +611,12 @@ func (r *OnloadReconciler) handleNodeUpdate(ctx context.Context, onload *onloadv
 	}
 
 	// Evict pods using the onload resource
-	res, err := r.evictOnloadedPods(ctx, node)
+	resourceNames, err := r.getOnloadResourceNames(ctx, onload, node)
+	if err != nil {
+		log.Error(err, "Failed to get the Onload resources on Node "+node.Name)
+		return nil, err
+	}
+	res, err := r.evictOnloadedPods(ctx, node, resourceNames)
 	if err != nil || res != nil {
 		return res, err
 	}
@@ -610,7 +651,7 @@ func (r *OnloadReconciler) handleUpdate(ctx context.Context, onload *onloadv1alp
 		return res, err
 	}
 
-	res, err = r.handleModuleUpdate(ctx, onload)
+	res, err = r.moduleLoader().UpdateModules(ctx, onload)
 	if err != nil || res != nil {
 		return res, err
 	}
@@ -653,7 +694,106 @@ func (r *OnloadReconciler) getPodsOnNode(ctx context.Context, labelSelector map[
 	return podList.Items, nil
 }
 
-func (r *OnloadReconciler) getPodsUsingOnload(ctx context.Context, node corev1.Node) ([]corev1.Pod, error) {
+// The node annotation in which the Onload Device Plugin lists the containers
+// it has allocated Onload devices to.
+const allocationsAnnotation = onloadLabelPrefix + "allocations"
+
+// onloadAllocation is an entry in the allocations annotation.
+type onloadAllocation struct {
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod"`
+	Container string   `json:"container"`
+	Resource  string   `json:"resource"`
+	DeviceIDs []string `json:"deviceIDs"`
+}
+
+// Returns the pods which the Onload Device Plugin has recorded as holding
+// Onload devices on the node.
+func podsWithAllocations(node corev1.Node) (map[types.NamespacedName]bool, error) {
+	annotation, found := node.Annotations[allocationsAnnotation]
+	if !found {
+		return nil, nil
+	}
+
+	allocations := []onloadAllocation{}
+	err := json.Unmarshal([]byte(annotation), &allocations)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on Node %s (%w)",
+			allocationsAnnotation, node.Name, err)
+	}
+
+	pods := map[types.NamespacedName]bool{}
+	for _, allocation := range allocations {
+		pods[types.NamespacedName{Namespace: allocation.Namespace, Name: allocation.Pod}] = true
+	}
+	return pods, nil
+}
+
+// The name of the Onload Device Plugin's resource unless the Onload CR
+// renames it.
+const defaultOnloadResourceName = "amd.com/onload"
+
+// Returns the name of the resource advertised by the Onload CR's Onload Device
+// Plugin.
+func onloadResourceName(onload *onloadv1alpha1.Onload) string {
+	return ptr.Deref(onload.Spec.DevicePlugin.ResourceName, defaultOnloadResourceName)
+}
+
+// The API resources advertised by every Onload Device Plugin which enables
+// them.
+var onloadAPIResourceNames = []corev1.ResourceName{"amd.com/tcpdirect", "amd.com/efvi"}
+
+// Returns the names of the resources which the Onload Device Plugin with the
+// given shared resource name advertises on the node: the shared resource, the
+// API resources and those restricted to an interface. The latter are matched
+// by their exact names in the node's capacity, rather than by prefix, so that
+// another Onload CR's resources, whose names may extend ours, aren't included.
+func onloadResourceNames(node corev1.Node, resourceName string, otherResourceNames []string,
+) map[corev1.ResourceName]bool {
+	names := map[corev1.ResourceName]bool{corev1.ResourceName(resourceName): true}
+	for _, name := range onloadAPIResourceNames {
+		names[name] = true
+	}
+
+	for name := range node.Status.Capacity {
+		if !strings.HasPrefix(string(name), resourceName+"-") {
+			continue
+		}
+		if slices.ContainsFunc(otherResourceNames, func(other string) bool {
+			return len(other) > len(resourceName) &&
+				(string(name) == other || strings.HasPrefix(string(name), other+"-"))
+		}) {
+			continue
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// Returns the names of the resources which the Onload CR's Onload Device
+// Plugin advertises on the node.
+func (r *OnloadReconciler) getOnloadResourceNames(ctx context.Context, onload *onloadv1alpha1.Onload,
+	node corev1.Node,
+) (map[corev1.ResourceName]bool, error) {
+	onloads := onloadv1alpha1.OnloadList{}
+	err := r.List(ctx, &onloads)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceName := onloadResourceName(onload)
+	others := []string{}
+	for i := range onloads.Items {
+		if other := onloadResourceName(&onloads.Items[i]); other != resourceName {
+			others = append(others, other)
+		}
+	}
+	return onloadResourceNames(node, resourceName, others), nil
+}
+
+func (r *OnloadReconciler) getPodsUsingOnload(ctx context.Context, node corev1.Node,
+	resourceNames map[corev1.ResourceName]bool,
+) ([]corev1.Pod, error) {
 	log := log.FromContext(ctx)
 
 	podsUsingOnload := []corev1.Pod{}
@@ -663,6 +803,15 @@ func (r *OnloadReconciler) getPodsUsingOnload(ctx context.Context, node corev1.N
 		log.Error(err, "Failed to list Pods")
 	}
 
+	// The allocations recorded by the Onload Device Plugin, which it gets from
+	// the kubelet, include pods using a resource the CR no longer advertises.
+	// They are only published periodically though, so also include any pod
+	// requesting an Onload resource in case it was allocated since.
+	allocated, err := podsWithAllocations(node)
+	if err != nil {
+		log.Error(err, "Ignoring the allocations recorded by the Device Plugin")
+	}
+
 	// Ideally this function should be using a field selector that matches both
 	// node.Name and pods the resource request, unfortunately k8s doesn't
 	// currently support multiple requirements in a field selector. This means
@@ -671,22 +820,34 @@ func (r *OnloadReconciler) getPodsUsingOnload(ctx context.Context, node corev1.N
 	// https://github.com/kubernetes-sigs/controller-runtime/blob/d5bc8734caccabddac6a1bea250b0b9d771d318d/pkg/internal/field/selector/utils.go#L27
 
 	for _, pod := range allPods {
-		for _, container := range pod.Spec.Containers {
-			numOnloads := container.Resources.Requests.Name("amd.com/onload", resource.DecimalSI)
-			if numOnloads != nil && numOnloads.CmpInt64(0) > 0 {
-				podsUsingOnload = append(podsUsingOnload, pod)
-				break
-			}
+		if allocated[types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}] ||
+			slices.ContainsFunc(pod.Spec.Containers, func(container corev1.Container) bool {
+				return requestsOnload(container, resourceNames)
+			}) {
+			podsUsingOnload = append(podsUsingOnload, pod)
 		}
 	}
 
 	return podsUsingOnload, nil
 }
 
-func (r *OnloadReconciler) evictOnloadedPods(ctx context.Context, node corev1.Node) (*ctrl.Result, error) {
+// Returns true if the container requests any Onload resource, given their
+// names.
+func requestsOnload(container corev1.Container, resourceNames map[corev1.ResourceName]bool) bool {
+	for name, quantity := range container.Resources.Requests {
+		if resourceNames[name] && quantity.CmpInt64(0) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *OnloadReconciler) evictOnloadedPods(ctx context.Context, node corev1.Node,
+	resourceNames map[corev1.ResourceName]bool,
+) (*ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
-	onloadedPods, err := r.getPodsUsingOnload(ctx, node)
+	onloadedPods, err := r.getPodsUsingOnload(ctx, node, resourceNames)
 	if err != nil {
 		log.Error(err, "Failed to get Pods using Onload")
 		return nil, err
@@ -728,6 +889,10 @@ func onloadKernelMapper(spec onloadv1alpha1.OnloadKernelMapping) *kmm.KernelMapp
 		buildSpec = &kmm.Build{
 			BuildArgs:           make([]kmm.BuildArg, 0),
 			DockerfileConfigMap: spec.Build.DockerfileConfigMap,
+			Secrets:             spec.Build.Secrets,
+		}
+		if spec.Build.BaseImageRegistryTLS != nil {
+			buildSpec.BaseImageRegistryTLS = kmm.TLSOptions(*spec.Build.BaseImageRegistryTLS)
 		}
 		for _, buildArg := range spec.Build.BuildArgs {
 			arg := kmm.BuildArg{
@@ -738,10 +903,27 @@ func onloadKernelMapper(spec onloadv1alpha1.OnloadKernelMapping) *kmm.KernelMapp
 		}
 	}
 
+	var signSpec *kmm.Sign = nil
+	if spec.Sign != nil {
+		signSpec = &kmm.Sign{
+			UnsignedImage: spec.Sign.UnsignedImage,
+			KeySecret:     spec.Sign.KeySecret,
+			CertSecret:    spec.Sign.CertSecret,
+			FilesToSign:   spec.Sign.FilesToSign,
+		}
+	}
+
+	var registryTLS *kmm.TLSOptions = nil
+	if spec.RegistryTLS != nil {
+		registryTLS = (*kmm.TLSOptions)(spec.RegistryTLS)
+	}
+
 	return &kmm.KernelMapping{
 		Regexp:         spec.Regexp,
 		ContainerImage: spec.KernelModuleImage,
 		Build:          buildSpec,
+		Sign:           signSpec,
+		RegistryTLS:    registryTLS,
 	}
 }
 
@@ -752,10 +934,12 @@ func sfcKernelMapper(spec onloadv1alpha1.OnloadKernelMapping) *kmm.KernelMapping
 		return nil
 	}
 
-	// Otherwise, it reuses the Onload image.
+	// Otherwise, it reuses the Onload image, which is built and signed
+	// by the Onload module.
 	kmap := onloadKernelMapper(spec)
 
 	kmap.Build = nil
+	kmap.Sign = nil
 	return kmap
 }
 
@@ -767,85 +951,15 @@ func onloadUsesSFC(onload *onloadv1alpha1.Onload) bool {
 		})
 }
 
-func (r *OnloadReconciler) createAndAddModules(
-	ctx context.Context,
-	onload *onloadv1alpha1.Onload,
-) (*ctrl.Result, error) {
-	log := log.FromContext(ctx)
-
-	createAndAddModule := func(moduleName string,
-		modprobeArg string, inTreeModuleToRemove string, getKernelMap kernelMapperFn,
-	) (*ctrl.Result, error) {
-		module := &kmm.Module{}
-		err := r.Get(
-			ctx,
-			types.NamespacedName{
-				Name:      moduleName,
-				Namespace: onload.Namespace,
-			},
-			module,
-		)
-		if err == nil {
-			return nil, nil
-		}
-		if !apierrors.IsNotFound(err) {
-			log.Error(err, "Failed to get Module", "Module", moduleName)
-			return nil, err
-		}
-
-		module, err = createModule(onload, moduleName,
-			modprobeArg, inTreeModuleToRemove, getKernelMap)
-		if err != nil {
-			log.Error(err, "createModule failure")
-			return nil, err
-		}
-
-		err = ctrl.SetControllerReference(onload, module, r.Scheme)
-		if err != nil {
-			log.Error(err, "Failed to set owner of newly created Module")
-			return nil, err
-		}
-
-		err = r.Create(ctx, module)
-		if err != nil {
-			log.Error(err, "Failed to create new Module")
-			return nil, err
-		}
-
-		return &ctrl.Result{Requeue: true}, nil
-	}
-
-	res, err := createAndAddModule(onload.Name+onloadModuleNameSuffix, "onload", "", onloadKernelMapper)
-	if res != nil || err != nil {
-		return res, err
-	}
-
-	if onloadUsesSFC(onload) {
-		return createAndAddModule(onload.Name+sfcModuleNameSuffix, "sfc", "sfc", sfcKernelMapper)
-	}
-
-	return nil, nil
-}
-
 func createModule(
 	onload *onloadv1alpha1.Onload,
 	moduleName string,
 	modprobeArg string, inTreeModuleToRemove string, getKernelMap kernelMapperFn,
 ) (*kmm.Module, error) {
 
-	kernelMappings := []kmm.KernelMapping{}
-
-	for _, kmapSpec := range onload.Spec.Onload.KernelMappings {
-		kmap := getKernelMap(kmapSpec)
-
-		// We may not need to create a new Module kind for this mapping,
-		// e.g. if this is SFC and the user has deployed their kernel
-		// module managed outside the controller.
-		if kmap == nil {
-			continue
-		}
-
-		kernelMappings = append(kernelMappings, *kmap)
+	kernelMappings, err := moduleKernelMappings(onload, getKernelMap)
+	if err != nil {
+		return nil, err
 	}
 
 	module := &kmm.Module{
@@ -869,7 +983,8 @@ func createModule(
 					Version:         onload.Spec.Onload.Version,
 				},
 			},
-			Selector: onload.Spec.Selector,
+			ImageRepoSecret: onload.Spec.Onload.ImageRepoSecret,
+			Selector:        onload.Spec.Selector,
 		},
 	}
 
@@ -877,6 +992,7 @@ func createModule(
 }
 
 const devicePluginNameSuffix = "-onload-device-plugin-ds"
+const devicePluginContainerName = "device-plugin"
 const onloadVersionLabel = onloadLabelPrefix + "version"
 
 func (r *OnloadReconciler) createDevicePluginDaemonSet(
@@ -902,99 +1018,55 @@ func (r *OnloadReconciler) createDevicePluginDaemonSet(
 		return nil, err
 	}
 
-	hostOnloadPath := "/opt/onload"
-	if onload.Spec.DevicePlugin.HostOnloadPath != nil {
-		hostOnloadPath = *onload.Spec.DevicePlugin.HostOnloadPath
-	}
-
-	postStart := &corev1.LifecycleHandler{
-		Exec: &corev1.ExecAction{
-			Command: []string{
-				"/bin/sh", "-c",
-				fmt.Sprintf(`set -e;
-				chcon --type container_file_t --recursive %s ||
-				echo "chcon failed. System may not be SELinux enabled.";`, hostOnloadPath),
-			},
-		},
-	}
-
-	preStop := &corev1.LifecycleHandler{
-		Exec: &corev1.ExecAction{
-			Command: []string{
-				"/bin/sh", "-c",
-				fmt.Sprintf(`set -e;
-				rm -r %s;`, hostOnloadPath),
-			},
-		},
-	}
-
 	devicePluginContainer := corev1.Container{
-		Name:            "device-plugin",
+		Name:            devicePluginContainerName,
 		Image:           r.DevicePluginImage,
 		ImagePullPolicy: onload.Spec.DevicePlugin.ImagePullPolicy,
 		SecurityContext: &corev1.SecurityContext{
 			Privileged: ptr.To(true),
 		},
-		// Lifecycle to manage the Onload files in the host.
-		Lifecycle: &corev1.Lifecycle{
-			PostStart: postStart,
-			PreStop:   preStop,
-		},
+		Lifecycle: devicePluginLifecycle(onload),
 		VolumeMounts: []corev1.VolumeMount{
 			{
 				MountPath: "/var/lib/kubelet/device-plugins",
 				Name:      "kubelet-socket",
 			},
 			{
-				MountPath: hostOnloadPath,
-				Name:      "host-onload",
+				MountPath: "/var/lib/kubelet/pod-resources",
+				Name:      "pod-resources",
+			},
+		},
+		// The device plugin annotates its node with the pods using Onload.
+		Env: []corev1.EnvVar{
+			{
+				Name: "NODE_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						FieldPath: "spec.nodeName",
+					},
+				},
 			},
 		},
 	}
 
-	devicePluginArgs := []string{}
-	if onload.Spec.DevicePlugin.MaxPodsPerNode != nil {
-		devicePluginArgs = append(devicePluginArgs,
-			fmt.Sprintf("-maxPods=%d", *onload.Spec.DevicePlugin.MaxPodsPerNode))
-	}
-
-	if onload.Spec.DevicePlugin.SetPreload != nil {
-		devicePluginArgs = append(devicePluginArgs,
-			fmt.Sprintf("-setPreload=%t", *onload.Spec.DevicePlugin.SetPreload))
-	}
-
-	if onload.Spec.DevicePlugin.MountOnload != nil {
-		devicePluginArgs = append(devicePluginArgs,
-			fmt.Sprintf("-mountOnload=%t", *onload.Spec.DevicePlugin.MountOnload))
-	}
-
-	if onload.Spec.DevicePlugin.HostOnloadPath != nil {
-		devicePluginArgs = append(devicePluginArgs,
-			fmt.Sprintf("-hostOnloadPath=%s",
-				*onload.Spec.DevicePlugin.HostOnloadPath))
-	}
-
-	if onload.Spec.DevicePlugin.BaseMountPath != nil {
-		devicePluginArgs = append(devicePluginArgs,
-			fmt.Sprintf("-baseMountPath=%s",
-				*onload.Spec.DevicePlugin.BaseMountPath))
-	}
-
-	if onload.Spec.DevicePlugin.BinMountPath != nil {
-		devicePluginArgs = append(devicePluginArgs,
-			fmt.Sprintf("-binMountPath=%s",
-				*onload.Spec.DevicePlugin.BinMountPath))
-	}
-
-	if onload.Spec.DevicePlugin.LibMountPath != nil {
-		devicePluginArgs = append(devicePluginArgs,
-			fmt.Sprintf("-libMountPath=%s",
-				*onload.Spec.DevicePlugin.LibMountPath))
+	devicePluginContainer.Args = []string{
+		fmt.Sprintf("-config=%s", devicePluginConfigPath()),
+		fmt.Sprintf("-metricsAddress=:%d", devicePluginMetricsPort),
+		fmt.Sprintf("-debugAddress=127.0.0.1:%d", devicePluginDebugPort),
 	}
-
-	if len(devicePluginArgs) > 0 {
-		devicePluginContainer.Args = devicePluginArgs
+	devicePluginContainer.Ports = []corev1.ContainerPort{
+		{
+			Name:          devicePluginMetricsPortName,
+			ContainerPort: devicePluginMetricsPort,
+			Protocol:      corev1.ProtocolTCP,
+		},
 	}
+	devicePluginContainer.VolumeMounts = append(devicePluginContainer.VolumeMounts,
+		corev1.VolumeMount{
+			MountPath: devicePluginConfigDir,
+			Name:      "device-plugin-config",
+			ReadOnly:  true,
+		})
 
 	workerContainerName := "onload-worker"
 
@@ -1064,9 +1136,23 @@ func (r *OnloadReconciler) createDevicePluginDaemonSet(
 			// filesystem. `mkdir -p` is used in the case of a node reboot the
 			// emptyDir might be older than the actual pod, so the initContainer
 			// shouldn't fail if the directory already exists.
+			// The version of the userland is recorded so that the device
+			// plugin can check that it matches the loaded onload module. It is
+			// the first line of `onload --version`, eg. "Onload 8.1.2.26" or
+			// "OpenOnload 8.1.2.26". The init container fails if it can't be
+			// found, rather than leaving the device plugin unable to check it.
 			`set -e;
 			cp -TRv /opt/onload /host/onload;
 
+			rm -f /host/onload/onload-version;
+			version=$(ONLOAD_PRELOAD=/opt/onload/usr/lib64/libonload.so \
+				/opt/onload/usr/bin/onload --version | sed -n -E '1s/^(Open)?Onload //p');
+			if [ -z "$version" ]; then
+				echo "Unable to find the Onload userland version in onload --version" >&2;
+				exit 1;
+			fi;
+			echo "$version" > /host/onload/onload-version;
+
 			mkdir -vp /mnt/onload/sbin/;
 			cp -v /opt/onload/sbin/onload_cp_server /mnt/onload/sbin/;
 			`,
@@ -1081,7 +1167,7 @@ func (r *OnloadReconciler) createDevicePluginDaemonSet(
 				// device plugin, only whether they are both looking at the same
 				// volumeMount.
 				MountPath: "/host/onload",
-				Name:      "host-onload",
+				Name:      hostOnloadVolumeName,
 			},
 			{
 				MountPath: "/mnt/onload",
@@ -1106,12 +1192,12 @@ func (r *OnloadReconciler) createDevicePluginDaemonSet(
 		},
 	}
 
-	hostOnloadVolume := corev1.Volume{
-		Name: "host-onload",
+	podResourcesVolume := corev1.Volume{
+		Name: "pod-resources",
 		VolumeSource: corev1.VolumeSource{
 			HostPath: &corev1.HostPathVolumeSource{
-				Path: hostOnloadPath,
-				Type: ptr.To(corev1.HostPathDirectoryOrCreate),
+				Path: "/var/lib/kubelet/pod-resources",
+				Type: ptr.To(corev1.HostPathDirectory),
 			},
 		},
 	}
@@ -1125,6 +1211,28 @@ func (r *OnloadReconciler) createDevicePluginDaemonSet(
 		},
 	}
 
+	devicePluginConfigVolume := corev1.Volume{
+		Name: "device-plugin-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: onload.Name + devicePluginConfigNameSuffix,
+				},
+			},
+		},
+	}
+
+	// The volumes which depend on settings that can change after the
+	// DaemonSet is created are reconciled by reconcileDevicePluginVolumes.
+	reconciledVolumes, reconciledMounts := devicePluginVolumes(onload)
+	volumes := append([]corev1.Volume{
+		kubeletSocketVolume,
+		podResourcesVolume,
+		emptyDirVolume,
+		devicePluginConfigVolume,
+	}, reconciledVolumes...)
+	devicePluginContainer.VolumeMounts = append(devicePluginContainer.VolumeMounts, reconciledMounts...)
+
 	devicePlugin = &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      devicePluginName,
@@ -1144,11 +1252,7 @@ func (r *OnloadReconciler) createDevicePluginDaemonSet(
 						devicePluginContainer,
 						workerContainer,
 					},
-					Volumes: []corev1.Volume{
-						kubeletSocketVolume,
-						hostOnloadVolume,
-						emptyDirVolume,
-					},
+					Volumes:      volumes,
 					NodeSelector: onload.Spec.Selector,
 					Affinity: &corev1.Affinity{
 						NodeAffinity: &corev1.NodeAffinity{
@@ -1167,10 +1271,11 @@ func (r *OnloadReconciler) createDevicePluginDaemonSet(
 							},
 						},
 					},
-					InitContainers: []corev1.Container{initContainer},
-					HostNetwork:    true,
-					HostPID:        true,
-					HostIPC:        true,
+					InitContainers:   []corev1.Container{initContainer},
+					ImagePullSecrets: onload.Spec.Onload.ImagePullSecrets,
+					HostNetwork:      true,
+					HostPID:          true,
+					HostIPC:          true,
 				},
 			},
 		},
@@ -1220,11 +1325,15 @@ func (r *OnloadReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&onloadv1alpha1.Onload{}).
-		Owns(&kmm.Module{}).
 		Owns(&appsv1.DaemonSet{}).
 		Watches(&corev1.Node{},
-			handler.EnqueueRequestsFromMapFunc(r.nodeLabelWatchFunc)).
-		Complete(r)
+			handler.EnqueueRequestsFromMapFunc(r.nodeLabelWatchFunc))
+
+	if owned := r.moduleLoader().OwnedObject(); owned != nil {
+		builder = builder.Owns(owned)
+	}
+
+	return builder.Complete(r)
 }
@@ -13,10 +13,13 @@ import (
 	. "github.com/onsi/gomega/gstruct"
 	"go.uber.org/mock/gomock"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/utils/ptr"
@@ -66,6 +69,16 @@ var _ = Describe("Testing createModule function", func() {
 		Expect(len(module.Spec.ModuleLoader.Container.KernelMappings)).
 			To(Equal(len(onload.Spec.Onload.KernelMappings)))
 	})
+
+	It("Should pass the image repo secret through", func() {
+		secret := &corev1.LocalObjectReference{Name: "registry-secret"}
+		onload.Spec.Onload.ImageRepoSecret = secret
+
+		module, err := createModule(onload, "example", "example", "example", exampleKernelMapper)
+		Expect(err).Should(Succeed())
+
+		Expect(module.Spec.ImageRepoSecret).To(Equal(secret))
+	})
 })
 
 var _ = Describe("Testing onloadUsesSFC predicate", func() {
@@ -189,6 +202,34 @@ var _ = Describe("Testing kernelmapping conversion functions", func() {
 		})))
 	})
 
+	It("should map the build secrets and TLS options in onloadKernelMapper", func() {
+		onloadBuild.Secrets = []corev1.LocalObjectReference{{Name: "git-credentials"}}
+		onloadBuild.BaseImageRegistryTLS = &onloadv1alpha1.TLSOptions{InsecureSkipTLSVerify: true}
+		onloadKmap.Build = &onloadBuild
+
+		kmmKmap := onloadKernelMapper(onloadKmap)
+
+		Expect(kmmKmap).ShouldNot(BeNil())
+		Expect(kmmKmap.Build).Should(PointTo(MatchFields(IgnoreExtras, Fields{
+			"Secrets": Equal(onloadBuild.Secrets),
+			"BaseImageRegistryTLS": Equal(kmm.TLSOptions{
+				Insecure:              false,
+				InsecureSkipTLSVerify: true,
+			}),
+		})))
+	})
+
+	It("should map the registry TLS options in both kernel mappers", func() {
+		onloadKmap.RegistryTLS = &onloadv1alpha1.TLSOptions{Insecure: true}
+		onloadKmap.SFC = &onloadv1alpha1.SFCSpec{}
+
+		expected := PointTo(MatchFields(IgnoreExtras, Fields{
+			"RegistryTLS": PointTo(Equal(kmm.TLSOptions{Insecure: true})),
+		}))
+		Expect(onloadKernelMapper(onloadKmap)).Should(expected)
+		Expect(sfcKernelMapper(onloadKmap)).Should(expected)
+	})
+
 	It("shouldn't map the build parameters in sfcKernelMapper", func() {
 		onloadKmap.Build = &onloadBuild
 		onloadKmap.SFC = &onloadv1alpha1.SFCSpec{}
@@ -197,6 +238,131 @@ var _ = Describe("Testing kernelmapping conversion functions", func() {
 		Expect(kmmKmap).ShouldNot(BeNil())
 		Expect(kmmKmap.Build).Should(BeNil())
 	})
+
+	It("should map the sign parameters in onloadKernelMapper", func() {
+		onloadKmap.Sign = &onloadv1alpha1.OnloadKernelSign{
+			UnsignedImage: "image:unsigned",
+			KeySecret:     &corev1.LocalObjectReference{Name: "key"},
+			CertSecret:    &corev1.LocalObjectReference{Name: "cert"},
+			FilesToSign:   []string{"/opt/lib/modules/onload.ko"},
+		}
+
+		kmmKmap := onloadKernelMapper(onloadKmap)
+
+		Expect(kmmKmap).ShouldNot(BeNil())
+		Expect(kmmKmap.Sign).Should(PointTo(MatchAllFields(Fields{
+			"UnsignedImage":            Equal("image:unsigned"),
+			"UnsignedImageRegistryTLS": BeZero(),
+			"KeySecret":                Equal(onloadKmap.Sign.KeySecret),
+			"CertSecret":               Equal(onloadKmap.Sign.CertSecret),
+			"FilesToSign":              Equal(onloadKmap.Sign.FilesToSign),
+		})))
+	})
+
+	It("shouldn't map the sign parameters in sfcKernelMapper", func() {
+		onloadKmap.Sign = &onloadv1alpha1.OnloadKernelSign{}
+		onloadKmap.SFC = &onloadv1alpha1.SFCSpec{}
+		kmmKmap := sfcKernelMapper(onloadKmap)
+
+		Expect(kmmKmap).ShouldNot(BeNil())
+		Expect(kmmKmap.Sign).Should(BeNil())
+	})
+})
+
+var _ = Describe("Testing module signing status", func() {
+	failedJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "failed"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	activeJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "active"},
+		Status:     batchv1.JobStatus{Active: 1},
+	}
+	completeJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "complete"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+
+	DescribeTable("should summarise the sign jobs",
+		func(jobs []batchv1.Job, loaded bool, status metav1.ConditionStatus, reason string) {
+			condition := signingCondition(jobs, loaded)
+			Expect(condition.Type).Should(Equal(conditionModulesSigned))
+			Expect(condition.Status).Should(Equal(status))
+			Expect(condition.Reason).Should(Equal(reason))
+		},
+		Entry( /*It*/ "should wait for jobs to be created", nil, false,
+			metav1.ConditionUnknown, "SigningPending"),
+		Entry( /*It*/ "should succeed without jobs once the modules are loaded", nil, true,
+			metav1.ConditionTrue, "SigningSucceeded"),
+		Entry( /*It*/ "should succeed with complete jobs", []batchv1.Job{completeJob}, false,
+			metav1.ConditionTrue, "SigningSucceeded"),
+		Entry( /*It*/ "should wait for active jobs", []batchv1.Job{completeJob, activeJob}, true,
+			metav1.ConditionUnknown, "SigningInProgress"),
+		Entry( /*It*/ "should report failed jobs", []batchv1.Job{activeJob, failedJob}, true,
+			metav1.ConditionFalse, "SigningFailed"),
+	)
+
+	It("should name the failed jobs", func() {
+		Expect(signingCondition([]batchv1.Job{failedJob}, false).Message).Should(ContainSubstring("failed"))
+	})
+})
+
+var _ = Describe("Testing kernel mapping matching", func() {
+	kmaps := []onloadv1alpha1.OnloadKernelMapping{
+		{Regexp: "^5\\.14\\..*$"},
+		{Regexp: "^5\\..*$"},
+	}
+
+	node := func(name, kernelVersion string) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				NodeInfo: corev1.NodeSystemInfo{KernelVersion: kernelVersion},
+			},
+		}
+	}
+
+	It("should classify nodes by the number of matching kernel mappings", func() {
+		unmatched, ambiguous, err := classifyNodes(kmaps, []corev1.Node{
+			node("d", "6.1.0"),
+			node("c", "5.14.0-284.el9.x86_64"),
+			node("b", "5.15.0"),
+			node("a", "4.18.0"),
+			node("e", ""),
+		})
+		Expect(err).Should(Succeed())
+		Expect(unmatched).Should(Equal([]string{"a", "d"}))
+		Expect(ambiguous).Should(Equal([]string{"c"}))
+	})
+
+	It("should fail on an invalid regexp", func() {
+		_, err := kernelMappingMatches([]onloadv1alpha1.OnloadKernelMapping{{Regexp: "("}}, "5.14.0")
+		Expect(err).Should(HaveOccurred())
+	})
+
+	DescribeTable("should summarise the matches",
+		func(unmatched []string, ambiguous []string, status metav1.ConditionStatus, reason string) {
+			Expect(kernelMappingCondition(unmatched, ambiguous)).Should(MatchFields(IgnoreExtras, Fields{
+				"Type":   Equal(conditionKernelMappingsMatched),
+				"Status": Equal(status),
+				"Reason": Equal(reason),
+			}))
+		},
+		Entry( /*It*/ "should succeed when all nodes match", nil, nil,
+			metav1.ConditionTrue, "AllNodesMatched"),
+		Entry( /*It*/ "should report multiple matches", nil, []string{"a"},
+			metav1.ConditionFalse, "NodesMatchMultipleMappings"),
+		Entry( /*It*/ "should report unmatched nodes first", []string{"a"}, []string{"b"},
+			metav1.ConditionFalse, "NodesUnmatched"),
+	)
 })
 
 var _ = Describe("Testing using mocked client", func() {
@@ -262,7 +428,446 @@ var _ = Describe("Testing using mocked client", func() {
 			Return(mockSubResourceClient).
 			Times(1)
 
-		Expect(r.evictOnloadedPods(ctx, node)).Should(Equal(&ctrl.Result{RequeueAfter: 5 * time.Second}))
+		Expect(r.evictOnloadedPods(ctx, node, onloadResourceNames(node, "amd.com/onload", nil))).Should(Equal(&ctrl.Result{RequeueAfter: 5 * time.Second}))
+	})
+
+	It("should only delete Dockerfile ConfigMaps the Module no longer uses", func() {
+		onload := onloadv1alpha1.Onload{
+			ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"},
+			Spec: onloadv1alpha1.Spec{
+				Onload: onloadv1alpha1.OnloadSpec{
+					KernelMappings: []onloadv1alpha1.OnloadKernelMapping{
+						{Build: &onloadv1alpha1.OnloadKernelBuild{Template: "dtk-ubi"}},
+					},
+					Version: "upgraded",
+				},
+			},
+		}
+		configMaps, err := dockerfileConfigMaps(&onload)
+		Expect(err).Should(Succeed())
+		Expect(configMaps).Should(HaveLen(1))
+		var current corev1.ConfigMap
+		for _, configMap := range configMaps {
+			current = *configMap
+		}
+
+		// The Module has yet to be updated to build with the new Dockerfile.
+		module := kmm.Module{
+			Spec: kmm.ModuleSpec{
+				ModuleLoader: kmm.ModuleLoaderSpec{
+					Container: kmm.ModuleLoaderContainerSpec{
+						KernelMappings: []kmm.KernelMapping{
+							{Build: &kmm.Build{
+								DockerfileConfigMap: &corev1.LocalObjectReference{Name: "name-dockerfile-old"},
+							}},
+						},
+					},
+				},
+			},
+		}
+		existing := corev1.ConfigMapList{
+			Items: []corev1.ConfigMap{
+				current,
+				{ObjectMeta: metav1.ObjectMeta{Name: "name-dockerfile-old"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "name-dockerfile-stale"}},
+			},
+		}
+
+		mockClient.EXPECT().
+			Get(gomock.Any(), types.NamespacedName{Name: "name-module", Namespace: "namespace"},
+				&kmm.Module{}).
+			SetArg(2, module).
+			Return(nil).
+			Times(1)
+
+		mockClient.EXPECT().
+			List(gomock.Any(), &corev1.ConfigMapList{}, gomock.Any()).
+			SetArg(1, existing).
+			Return(nil).
+			Times(1)
+
+		mockClient.EXPECT().
+			Delete(gomock.Any(), &existing.Items[2]).
+			Return(nil).
+			Times(1)
+
+		Expect(r.pruneDockerfiles(ctx, &onload)).Should(Succeed())
+	})
+
+	It("should leave the Dockerfiles alone with the native module loader", func() {
+		r.ModuleLoader = &nativeModuleLoader{Client: mockClient}
+		onload := onloadv1alpha1.Onload{
+			ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"},
+			Spec: onloadv1alpha1.Spec{
+				Onload: onloadv1alpha1.OnloadSpec{
+					KernelMappings: []onloadv1alpha1.OnloadKernelMapping{
+						{Build: &onloadv1alpha1.OnloadKernelBuild{Template: "dtk-ubi"}},
+					},
+				},
+			},
+		}
+
+		// The mocked client fails the test on any call.
+		Expect(r.createDockerfiles(ctx, &onload)).Should(BeNil())
+		Expect(r.pruneDockerfiles(ctx, &onload)).Should(Succeed())
+	})
+
+	It("should report that the native module loader doesn't sign modules", func() {
+		r.ModuleLoader = &nativeModuleLoader{Client: mockClient}
+		onload := onloadv1alpha1.Onload{
+			Spec: onloadv1alpha1.Spec{
+				Onload: onloadv1alpha1.OnloadSpec{
+					KernelMappings: []onloadv1alpha1.OnloadKernelMapping{
+						{Sign: &onloadv1alpha1.OnloadKernelSign{}},
+					},
+				},
+			},
+		}
+
+		mockClient.EXPECT().
+			Status().
+			Return(mockSubResourceClient).
+			Times(1)
+
+		mockSubResourceClient.EXPECT().
+			Update(gomock.Any(), &onload, gomock.Any()).
+			Return(nil).
+			Times(1)
+
+		Expect(r.updateSigningStatus(ctx, &onload)).Should(Succeed())
+		Expect(meta.FindStatusCondition(onload.Status.Conditions, conditionModulesSigned)).Should(
+			PointTo(MatchFields(IgnoreExtras, Fields{
+				"Status": Equal(metav1.ConditionFalse),
+				"Reason": Equal("SigningUnsupported"),
+			})))
+	})
+
+	Context("Device Plugin volumes", func() {
+		devicePlugin := func(spec onloadv1alpha1.DevicePluginSpec) appsv1.DaemonSet {
+			onload := onloadv1alpha1.Onload{}
+			onload.Spec.DevicePlugin = spec
+			reconciledVolumes, reconciledMounts := devicePluginVolumes(&onload)
+			volumes := append([]corev1.Volume{{Name: "device-plugin-config"}}, reconciledVolumes...)
+			mounts := append([]corev1.VolumeMount{{Name: "device-plugin-config"}}, reconciledMounts...)
+			// As defaulted by the API server.
+			for _, volume := range volumes {
+				if volume.ConfigMap != nil {
+					volume.ConfigMap.DefaultMode = ptr.To[int32](0644)
+				}
+			}
+
+			ds := appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "name-onload-device-plugin-ds"}}
+			ds.Spec.Template.Spec.Containers = []corev1.Container{
+				{Name: "device-plugin", VolumeMounts: mounts, Lifecycle: devicePluginLifecycle(&onload)},
+				{Name: "onload-worker"},
+			}
+			ds.Spec.Template.Spec.Volumes = volumes
+			return ds
+		}
+
+		DescribeTable("should patch the volumes when the Onload CR changes",
+			func(existing onloadv1alpha1.DevicePluginSpec, spec onloadv1alpha1.DevicePluginSpec, patched bool) {
+				onload := onloadv1alpha1.Onload{
+					ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"},
+				}
+				onload.Spec.DevicePlugin = spec
+
+				mockClient.EXPECT().
+					Get(gomock.Any(),
+						types.NamespacedName{Name: "name-onload-device-plugin-ds", Namespace: "namespace"},
+						&appsv1.DaemonSet{}).
+					SetArg(2, devicePlugin(existing)).
+					Return(nil).
+					Times(1)
+
+				if !patched {
+					Expect(r.reconcileDevicePluginVolumes(ctx, &onload)).Should(BeNil())
+					return
+				}
+
+				var podSpec corev1.PodSpec
+				mockClient.EXPECT().
+					Patch(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ any, ds client.Object, _ any, _ ...client.PatchOption) error {
+						podSpec = ds.(*appsv1.DaemonSet).Spec.Template.Spec
+						return nil
+					}).
+					Times(1)
+
+				Expect(r.reconcileDevicePluginVolumes(ctx, &onload)).Should(
+					Equal(&ctrl.Result{Requeue: true}))
+				wantedVolumes, wantedMounts := devicePluginVolumes(&onload)
+				Expect(devicePluginVolumesMatch(podSpec.Volumes, podSpec.Containers[0].VolumeMounts,
+					wantedVolumes, wantedMounts)).Should(BeTrue())
+				Expect(podSpec.Containers[0].Lifecycle).Should(Equal(devicePluginLifecycle(&onload)))
+				Expect(podSpec.Volumes).Should(ContainElement(HaveField("Name", "device-plugin-config")))
+				Expect(podSpec.Containers[0].VolumeMounts).Should(
+					ContainElement(HaveField("Name", "device-plugin-config")))
+			},
+			Entry( /*It*/ "should leave a DaemonSet with the defaults alone",
+				onloadv1alpha1.DevicePluginSpec{}, onloadv1alpha1.DevicePluginSpec{}, false),
+			Entry( /*It*/ "should leave a DaemonSet with the profile and CDI alone",
+				onloadv1alpha1.DevicePluginSpec{Profile: ptr.To("latency"), CDI: ptr.To(true)},
+				onloadv1alpha1.DevicePluginSpec{Profile: ptr.To("latency"), CDI: ptr.To(true)}, false),
+			Entry( /*It*/ "should add a profile",
+				onloadv1alpha1.DevicePluginSpec{}, onloadv1alpha1.DevicePluginSpec{Profile: ptr.To("latency")}, true),
+			Entry( /*It*/ "should rename a profile",
+				onloadv1alpha1.DevicePluginSpec{Profile: ptr.To("latency")},
+				onloadv1alpha1.DevicePluginSpec{Profile: ptr.To("throughput")}, true),
+			Entry( /*It*/ "should remove a profile",
+				onloadv1alpha1.DevicePluginSpec{Profile: ptr.To("latency")}, onloadv1alpha1.DevicePluginSpec{}, true),
+			Entry( /*It*/ "should mount the CDI spec directory when enabled",
+				onloadv1alpha1.DevicePluginSpec{}, onloadv1alpha1.DevicePluginSpec{CDI: ptr.To(true)}, true),
+			Entry( /*It*/ "should unmount the CDI spec directory when disabled",
+				onloadv1alpha1.DevicePluginSpec{CDI: ptr.To(true)}, onloadv1alpha1.DevicePluginSpec{CDI: ptr.To(false)},
+				true),
+			Entry( /*It*/ "should move the host Onload path",
+				onloadv1alpha1.DevicePluginSpec{},
+				onloadv1alpha1.DevicePluginSpec{HostOnloadPath: ptr.To("/var/onload")}, true),
+		)
+
+		It("should make a profile volume created without it optional", func() {
+			onload := onloadv1alpha1.Onload{}
+			onload.Spec.DevicePlugin.Profile = ptr.To("latency")
+			ds := devicePlugin(onload.Spec.DevicePlugin)
+			podSpec := ds.Spec.Template.Spec
+			podSpec.Volumes[2].ConfigMap.Optional = nil
+
+			wantedVolumes, wantedMounts := devicePluginVolumes(&onload)
+			Expect(devicePluginVolumesMatch(podSpec.Volumes, podSpec.Containers[0].VolumeMounts,
+				wantedVolumes, wantedMounts)).Should(BeFalse())
+		})
+
+		It("should move the host Onload path in the lifecycle", func() {
+			onload := onloadv1alpha1.Onload{}
+			onload.Spec.DevicePlugin.HostOnloadPath = ptr.To("/var/onload")
+			lifecycle := devicePluginLifecycle(&onload)
+			Expect(lifecycle.PostStart.Exec.Command[2]).Should(ContainSubstring("/var/onload"))
+			Expect(lifecycle.PreStop.Exec.Command[2]).Should(ContainSubstring("rm -r /var/onload;"))
+		})
+	})
+
+	Context("Image secrets", func() {
+		var onload onloadv1alpha1.Onload
+
+		BeforeEach(func() {
+			onload = onloadv1alpha1.Onload{
+				ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"},
+				Spec: onloadv1alpha1.Spec{
+					Onload: onloadv1alpha1.OnloadSpec{
+						Version:          "current",
+						UserImage:        "userImage:current",
+						ImageRepoSecret:  &corev1.LocalObjectReference{Name: "new-repo-secret"},
+						ImagePullSecrets: []corev1.LocalObjectReference{{Name: "new-pull-secret"}},
+					},
+				},
+			}
+		})
+
+		It("should patch the Module's image repo secret without an upgrade", func() {
+			loader := &kmmModuleLoader{Client: mockClient}
+			kernelMappings := []kmm.KernelMapping{{Regexp: "kernel"}}
+			module := kmm.Module{
+				ObjectMeta: metav1.ObjectMeta{Name: "name-onload-module", Namespace: "namespace"},
+				Spec: kmm.ModuleSpec{
+					ModuleLoader: kmm.ModuleLoaderSpec{
+						Container: kmm.ModuleLoaderContainerSpec{
+							Version:        "current",
+							KernelMappings: kernelMappings,
+						},
+					},
+					ImageRepoSecret: &corev1.LocalObjectReference{Name: "old-repo-secret"},
+				},
+			}
+
+			var patched kmm.ModuleSpec
+			mockClient.EXPECT().
+				Patch(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ any, obj client.Object, _ any, _ ...client.PatchOption) error {
+					patched = obj.(*kmm.Module).Spec
+					return nil
+				}).
+				Times(1)
+
+			Expect(loader.patchModule(ctx, &module, &onload, onloadKernelMapper)).Should(
+				Equal(&ctrl.Result{Requeue: true}))
+			Expect(patched.ImageRepoSecret).Should(Equal(onload.Spec.Onload.ImageRepoSecret))
+			Expect(patched.ModuleLoader.Container.Version).Should(Equal("current"))
+			Expect(patched.ModuleLoader.Container.KernelMappings).Should(Equal(kernelMappings))
+		})
+
+		It("should leave an up to date Module alone", func() {
+			loader := &kmmModuleLoader{Client: mockClient}
+			module := kmm.Module{
+				Spec: kmm.ModuleSpec{
+					ModuleLoader: kmm.ModuleLoaderSpec{
+						Container: kmm.ModuleLoaderContainerSpec{Version: "current"},
+					},
+					ImageRepoSecret: &corev1.LocalObjectReference{Name: "new-repo-secret"},
+				},
+			}
+
+			Expect(loader.patchModule(ctx, &module, &onload, onloadKernelMapper)).Should(BeNil())
+		})
+
+		It("should patch the Device Plugin's image pull secrets without an upgrade", func() {
+			ds := appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "name-onload-device-plugin-ds",
+					Labels: map[string]string{onloadVersionLabel: "current"},
+				},
+			}
+			ds.Spec.Template.Spec.InitContainers = []corev1.Container{{Image: "userImage:previous"}}
+			ds.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "old-pull-secret"}}
+
+			mockClient.EXPECT().
+				Get(gomock.Any(),
+					types.NamespacedName{Name: "name-onload-device-plugin-ds", Namespace: "namespace"},
+					&appsv1.DaemonSet{}).
+				SetArg(2, ds).
+				Return(nil).
+				Times(1)
+
+			var patched appsv1.DaemonSet
+			mockClient.EXPECT().
+				Patch(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ any, obj client.Object, _ any, _ ...client.PatchOption) error {
+					patched = *obj.(*appsv1.DaemonSet)
+					return nil
+				}).
+				Times(1)
+
+			Expect(r.handleDevicePluginUpdate(ctx, &onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+			Expect(patched.Spec.Template.Spec.ImagePullSecrets).Should(
+				Equal(onload.Spec.Onload.ImagePullSecrets))
+			Expect(patched.Spec.Template.Spec.InitContainers[0].Image).Should(Equal("userImage:previous"))
+		})
+	})
+
+	Context("Finding Pods using Onload", func() {
+		var allPods corev1.PodList
+
+		// The node advertises a resource restricted to an interface, and
+		// another flavour's resource, whose name extends ours.
+		capacity := corev1.ResourceList{
+			"amd.com/onload-enp2s0f0":   *resource.NewQuantity(1, resource.DecimalSI),
+			"amd.com/onload-enterprise": *resource.NewQuantity(1, resource.DecimalSI),
+		}
+
+		resourceNames := func(node corev1.Node, resourceName string) map[corev1.ResourceName]bool {
+			return onloadResourceNames(node, resourceName, []string{"amd.com/onload-enterprise"})
+		}
+
+		BeforeEach(func() {
+			onloadResource := resource.NewQuantity(1, resource.DecimalSI)
+			allPods = corev1.PodList{
+				Items: []corev1.Pod{
+					{ObjectMeta: metav1.ObjectMeta{Name: "A", Namespace: "default"}},
+					{ObjectMeta: metav1.ObjectMeta{Name: "B", Namespace: "default"},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{Resources: corev1.ResourceRequirements{
+									Requests: corev1.ResourceList{"amd.com/onload-enp2s0f0": *onloadResource}}},
+							},
+						},
+					},
+					{ObjectMeta: metav1.ObjectMeta{Name: "C", Namespace: "default"}},
+					{ObjectMeta: metav1.ObjectMeta{Name: "E", Namespace: "default"},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{Resources: corev1.ResourceRequirements{
+									Requests: corev1.ResourceList{"amd.com/tcpdirect": *onloadResource}}},
+							},
+						},
+					},
+					{ObjectMeta: metav1.ObjectMeta{Name: "F", Namespace: "default"},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{Resources: corev1.ResourceRequirements{
+									Requests: corev1.ResourceList{"amd.com/enterprise-onload": *onloadResource}}},
+							},
+						},
+					},
+					{ObjectMeta: metav1.ObjectMeta{Name: "G", Namespace: "default"},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{Resources: corev1.ResourceRequirements{
+									Requests: corev1.ResourceList{"amd.com/onload-enterprise": *onloadResource}}},
+							},
+						},
+					},
+				},
+			}
+
+			mockClient.EXPECT().
+				List(gomock.Any(), &corev1.PodList{}, gomock.Any()).
+				SetArg(1, allPods).
+				Return(nil).
+				Times(1)
+		})
+
+		It("should add the allocations recorded by the Device Plugin to resource requests", func() {
+			node := corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name: "node",
+				Annotations: map[string]string{
+					"onload.amd.com/allocations": `[
+						{"namespace": "default", "pod": "C", "container": "app",
+						 "resource": "amd.com/onload", "deviceIDs": ["sfc-0"]},
+						{"namespace": "default", "pod": "D", "container": "app",
+						 "resource": "amd.com/onload", "deviceIDs": ["sfc-1"]}
+					]`,
+				},
+			}, Status: corev1.NodeStatus{Capacity: capacity}}
+
+			Expect(r.getPodsUsingOnload(ctx, node, resourceNames(node, "amd.com/onload"))).Should(ConsistOf(
+				HaveField("Name", "B"),
+				HaveField("Name", "C"),
+				HaveField("Name", "E"),
+			))
+		})
+
+		It("should use resource requests without recorded allocations", func() {
+			node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"},
+				Status: corev1.NodeStatus{Capacity: capacity}}
+
+			Expect(r.getPodsUsingOnload(ctx, node, resourceNames(node, "amd.com/onload"))).Should(ConsistOf(
+				HaveField("Name", "B"),
+				HaveField("Name", "E"),
+			))
+		})
+
+		It("should use requests for a renamed resource", func() {
+			node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"},
+				Status: corev1.NodeStatus{Capacity: capacity}}
+
+			Expect(r.getPodsUsingOnload(ctx, node, resourceNames(node, "amd.com/enterprise-onload"))).Should(ConsistOf(
+				HaveField("Name", "E"),
+				HaveField("Name", "F"),
+			))
+		})
+
+		It("should ignore invalid allocations", func() {
+			node := corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node",
+				Annotations: map[string]string{"onload.amd.com/allocations": "{"},
+			}, Status: corev1.NodeStatus{Capacity: capacity}}
+
+			Expect(r.getPodsUsingOnload(ctx, node, resourceNames(node, "amd.com/onload"))).Should(ConsistOf(
+				HaveField("Name", "B"),
+				HaveField("Name", "E"),
+			))
+		})
+
+		It("should not use another flavour's resources", func() {
+			node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"},
+				Status: corev1.NodeStatus{Capacity: capacity}}
+
+			Expect(r.getPodsUsingOnload(ctx, node, resourceNames(node, "amd.com/onload-enterprise"))).
+				Should(ConsistOf(
+					HaveField("Name", "E"),
+					HaveField("Name", "G"),
+				))
+		})
 	})
 
 	Context("Node label management", func() {
@@ -428,6 +1033,143 @@ var _ = Describe("Testing using mocked client", func() {
 			Expect(r.addOnloadLabelsToNodes(ctx, &onload)).Should(Equal(&ctrl.Result{Requeue: true}))
 		})
 
+		It("should not label Nodes whose kernel matches no kernel mapping when skipping", func() {
+			onload.Spec.Onload.SkipUnmatchedNodes = true
+			onload.Spec.Onload.KernelMappings[0].Regexp = "^5\\..*$"
+			nodes.Items[0].Status.NodeInfo.KernelVersion = "6.1.0"
+
+			// Listing nodes, both to label them and to remove stale labels
+			mockClient.EXPECT().
+				List(gomock.Any(), &corev1.NodeList{}, gomock.Any()).
+				SetArg(1, nodes).
+				Return(nil).
+				Times(3)
+
+			Expect(r.addKmmLabelsToNodes(ctx, &onload)).Should(BeNil())
+		})
+
+		DescribeTable("should remove the labels from Nodes that no longer match one kernel mapping",
+			func(regexps []string) {
+				onload.Spec.Onload.SkipUnmatchedNodes = true
+				onload.Spec.Onload.KernelMappings = nil
+				for _, regexp := range regexps {
+					onload.Spec.Onload.KernelMappings = append(onload.Spec.Onload.KernelMappings,
+						onloadv1alpha1.OnloadKernelMapping{Regexp: regexp})
+				}
+				nodes.Items[0].Status.NodeInfo.KernelVersion = "6.1.0"
+				nodes.Items[0].Labels[kmmOnloadLabelName(onload.Name, onload.Namespace)] = "foo"
+				nodes.Items[0].Labels[onloadLabelName(onload.Name, onload.Namespace)] = "foo"
+
+				// Listing nodes
+				mockClient.EXPECT().
+					List(gomock.Any(), &corev1.NodeList{}, gomock.Any()).
+					SetArg(1, nodes).
+					Return(nil).
+					Times(1)
+
+				// Patching the node to remove the Onload label first, so that the
+				// Device Plugin pod stops before pods using Onload are evicted.
+				mockClient.EXPECT().
+					Patch(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ any, node client.Object, _ any, _ ...client.PatchOption) error {
+						Expect(node.GetLabels()).Should(Equal(map[string]string{
+							"key": "value",
+							kmmOnloadLabelName(onload.Name, onload.Namespace): "foo",
+						}))
+						return nil
+					}).
+					Times(1)
+
+				Expect(r.addKmmLabelsToNodes(ctx, &onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+			},
+			Entry( /*It*/ "should unlabel unmatched Nodes", []string{"^5\\..*$"}),
+			Entry( /*It*/ "should unlabel ambiguous Nodes", []string{"^6\\..*$", ".*"}),
+		)
+
+		It("should evict pods using Onload before unloading it from unmatched Nodes", func() {
+			onload.Spec.Onload.SkipUnmatchedNodes = true
+			onload.Spec.Onload.KernelMappings[0].Regexp = "^5\\..*$"
+			nodes.Items[0].Status.NodeInfo.KernelVersion = "6.1.0"
+			nodes.Items[0].Labels[kmmOnloadLabelName(onload.Name, onload.Namespace)] = "foo"
+
+			onloadPod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "onloaded", Namespace: "default"},
+				Spec: corev1.PodSpec{
+					NodeName: nodes.Items[0].Name,
+					Containers: []corev1.Container{
+						{Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								"amd.com/onload": *resource.NewQuantity(1, resource.DecimalSI),
+							}}},
+					},
+				},
+			}
+
+			// Listing nodes
+			mockClient.EXPECT().
+				List(gomock.Any(), &corev1.NodeList{}, gomock.Any()).
+				SetArg(1, nodes).
+				Return(nil).
+				Times(1)
+
+			// The Device Plugin pod has stopped, but the Onload pod is running.
+			listDPPodsCall := mockClient.EXPECT().
+				List(gomock.Any(), &corev1.PodList{}, gomock.Any()).
+				Return(nil).
+				Times(1)
+			mockClient.EXPECT().
+				List(gomock.Any(), &onloadv1alpha1.OnloadList{}).
+				Return(nil).
+				Times(1)
+			mockClient.EXPECT().
+				List(gomock.Any(), &corev1.PodList{}, gomock.Any()).
+				SetArg(1, corev1.PodList{Items: []corev1.Pod{onloadPod}}).
+				Return(nil).
+				Times(1).
+				After(listDPPodsCall)
+
+			// The pod is evicted, and the kmm label is left until it has gone.
+			mockClient.EXPECT().
+				SubResource("eviction").
+				Return(mockSubResourceClient).
+				Times(1)
+			mockSubResourceClient.EXPECT().
+				Create(gomock.Any(), &onloadPod, gomock.Any()).
+				Return(nil).
+				Times(1)
+
+			Expect(r.addKmmLabelsToNodes(ctx, &onload)).Should(
+				Equal(&ctrl.Result{RequeueAfter: 5 * time.Second}))
+		})
+
+		It("should report Nodes whose kernel matches no kernel mapping", func() {
+			onload.Spec.Onload.KernelMappings[0].Regexp = "^5\\..*$"
+			nodes.Items[0].Name = "node"
+			nodes.Items[0].Status.NodeInfo.KernelVersion = "6.1.0"
+
+			// Listing nodes
+			mockClient.EXPECT().
+				List(gomock.Any(), &corev1.NodeList{}, gomock.Any()).
+				SetArg(1, nodes).
+				Return(nil).
+				Times(1)
+
+			// Updating the status
+			mockClient.EXPECT().
+				Status().
+				Return(mockSubResourceClient).
+				Times(1)
+			mockSubResourceClient.EXPECT().
+				Update(gomock.Any(), &onload, gomock.Any()).
+				Return(nil).
+				Times(1)
+
+			Expect(r.updateKernelMappingStatus(ctx, &onload)).Should(Succeed())
+			Expect(onload.Status.Onload.UnmatchedNodes).Should(Equal([]string{"node"}))
+			Expect(meta.IsStatusConditionFalse(onload.Status.Conditions,
+				conditionKernelMappingsMatched)).Should(BeTrue())
+		})
+
 		It("should remove stale kmm labels from nodes that no longer match the selector", func() {
 			labelKey := kmmOnloadLabelName(onload.Name, onload.Namespace)
 
@@ -452,6 +1194,39 @@ var _ = Describe("Testing using mocked client", func() {
 			Expect(r.removeStaleLabels(ctx, &onload, labelKey)).Should(Equal(&ctrl.Result{Requeue: true}))
 		})
 
+		It("should remove the kernel version label when the Onload CR is deleted", func() {
+			kernelNode := corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "kernel",
+					Labels: map[string]string{kernelVersionLabel: "5.14.0"},
+				},
+			}
+
+			mockClient.EXPECT().
+				List(gomock.Any(), &corev1.NodeList{}, gomock.Any()).
+				DoAndReturn(func(_ any, list client.ObjectList, opts ...client.ListOption) error {
+					selector := opts[0].(client.MatchingLabelsSelector)
+					if selector.Matches(labels.Set(kernelNode.Labels)) {
+						list.(*corev1.NodeList).Items = []corev1.Node{kernelNode}
+					}
+					return nil
+				}).
+				Times(4)
+
+			var patched *corev1.Node
+			mockClient.EXPECT().
+				Patch(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ any, obj client.Object, _ any, _ ...client.PatchOption) error {
+					patched = obj.(*corev1.Node)
+					return nil
+				}).
+				Times(1)
+
+			Expect(r.deleteLabels(ctx, client.ObjectKeyFromObject(&onload))).Should(Succeed())
+			Expect(patched.Name).Should(Equal("kernel"))
+			Expect(patched.Labels).ShouldNot(HaveKey(kernelVersionLabel))
+		})
+
 	})
 
 	Context("Testing node updates", func() {
@@ -523,6 +1298,11 @@ var _ = Describe("Testing using mocked client", func() {
 				Return(nil).
 				Times(1)
 
+			mockClient.EXPECT().
+				List(gomock.Any(), &onloadv1alpha1.OnloadList{}).
+				Return(nil).
+				Times(1)
+
 			// No evictions needed, tests for that logic is handled in another
 			// unit test.
 			mockClient.EXPECT().
@@ -734,6 +1514,89 @@ var _ = Describe("onload controller", func() {
 				})))
 		})
 
+		It("should mount the CDI spec directory into the device plugin", func() {
+			devicePlugin := appsv1.DaemonSet{}
+			devicePluginName := types.NamespacedName{
+				Name:      onload.Name + "-onload-device-plugin-ds",
+				Namespace: onload.Namespace,
+			}
+
+			onload.Spec.DevicePlugin.CDI = ptr.To(true)
+			Expect(k8sClient.Create(ctx, onload)).To(BeNil())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, devicePluginName, &devicePlugin)
+				return err == nil
+			}, timeout, pollingInterval).Should(BeTrue())
+
+			Expect(devicePlugin.Spec.Template.Spec.Volumes).Should(ContainElement(
+				HaveField("HostPath.Path", "/var/run/cdi"),
+			))
+			Expect(devicePlugin.Spec.Template.Spec.Containers[0].VolumeMounts).Should(ContainElement(
+				HaveField("MountPath", "/var/run/cdi"),
+			))
+		})
+
+		It("should mount the profile into the device plugin", func() {
+			devicePlugin := appsv1.DaemonSet{}
+			devicePluginName := types.NamespacedName{
+				Name:      onload.Name + "-onload-device-plugin-ds",
+				Namespace: onload.Namespace,
+			}
+
+			onload.Spec.DevicePlugin.Profile = ptr.To("onload-latency-profile")
+			Expect(k8sClient.Create(ctx, onload)).To(BeNil())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, devicePluginName, &devicePlugin)
+				return err == nil
+			}, timeout, pollingInterval).Should(BeTrue())
+
+			Expect(devicePlugin.Spec.Template.Spec.Volumes).Should(ContainElement(And(
+				HaveField("ConfigMap.Name", "onload-latency-profile"),
+				HaveField("ConfigMap.Optional", PointTo(BeTrue())),
+			)))
+			Expect(devicePlugin.Spec.Template.Spec.Containers[0].VolumeMounts).Should(ContainElement(
+				HaveField("MountPath", "/etc/onload-profile"),
+			))
+		})
+
+		It("should expose the device plugin's metrics", func() {
+			devicePlugin := appsv1.DaemonSet{}
+			devicePluginName := types.NamespacedName{
+				Name:      onload.Name + "-onload-device-plugin-ds",
+				Namespace: onload.Namespace,
+			}
+			service := corev1.Service{}
+			serviceName := types.NamespacedName{
+				Name:      onload.Name + "-onload-device-plugin-metrics",
+				Namespace: onload.Namespace,
+			}
+
+			Expect(k8sClient.Create(ctx, onload)).To(BeNil())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, devicePluginName, &devicePlugin)
+				return err == nil
+			}, timeout, pollingInterval).Should(BeTrue())
+
+			Expect(devicePlugin.Spec.Template.Spec.Containers[0].Args).Should(
+				ContainElement("-metricsAddress=:9156"))
+			Expect(devicePlugin.Spec.Template.Spec.Containers[0].Args).Should(
+				ContainElement("-debugAddress=127.0.0.1:9157"))
+			Expect(devicePlugin.Spec.Template.Spec.Containers[0].Ports).Should(ContainElement(
+				MatchFields(IgnoreExtras, Fields{
+					"Name":          Equal("metrics"),
+					"ContainerPort": Equal(int32(9156)),
+				}),
+			))
+
+			Expect(k8sClient.Get(ctx, serviceName, &service)).Should(Succeed())
+			Expect(service.Spec.Selector).Should(Equal(map[string]string{
+				"onload.amd.com/name": devicePlugin.Name,
+			}))
+		})
+
 		// Test all four combinations of Onload CR upgrade: with/without SFC before/after
 		DescribeTable("Onload upgrade with and without SFC",
 			func(sfcBefore *onloadv1alpha1.SFCSpec, sfcAfter *onloadv1alpha1.SFCSpec) {
@@ -815,12 +1678,17 @@ var _ = Describe("onload controller", func() {
 		)
 
 		DescribeTable("Testing Device Plugin options",
-			func(dev *onloadv1alpha1.DevicePluginSpec, args string) {
+			func(dev *onloadv1alpha1.DevicePluginSpec, setting string) {
 				devicePlugin := appsv1.DaemonSet{}
 				devicePluginName := types.NamespacedName{
 					Name:      onload.Name + "-onload-device-plugin-ds",
 					Namespace: onload.Namespace,
 				}
+				config := corev1.ConfigMap{}
+				configName := types.NamespacedName{
+					Name:      onload.Name + "-onload-device-plugin-config",
+					Namespace: onload.Namespace,
+				}
 
 				if dev != nil {
 					onload.Spec.DevicePlugin = *dev
@@ -833,43 +1701,83 @@ var _ = Describe("onload controller", func() {
 					return err == nil
 				}, timeout, pollingInterval).Should(BeTrue())
 
-				if args != "" {
-					Expect(devicePlugin.Spec.Template.Spec.Containers).Should(
-						ContainElement(MatchFields(IgnoreExtras, Fields{
-							"Args": ContainElement(args),
-						})),
-					)
-				}
+				Expect(devicePlugin.Spec.Template.Spec.Containers).Should(
+					ContainElement(MatchFields(IgnoreExtras, Fields{
+						"Args": ContainElement("-config=/etc/onload-device-plugin/config.yaml"),
+					})),
+				)
 
+				Expect(k8sClient.Get(ctx, configName, &config)).Should(Succeed())
+				if setting != "" {
+					Expect(config.Data["config.yaml"]).Should(ContainSubstring(setting + "\n"))
+				} else {
+					Expect(config.Data["config.yaml"]).Should(Equal("{}\n"))
+				}
 			},
 			Entry( /*It*/ "shouldn't add anything when empty", nil, ""),
 			Entry( /*It*/ "should pass the value of maxPodsPerNode through",
 				&onloadv1alpha1.DevicePluginSpec{MaxPodsPerNode: ptr.To(1)},
-				"-maxPods=1",
+				"maxPodsPerNode: 1",
 			),
 			Entry( /*It*/ "should pass the value of setPreload through",
 				&onloadv1alpha1.DevicePluginSpec{SetPreload: ptr.To(false)},
-				"-setPreload=false",
+				"setPreload: false",
 			),
 			Entry( /*It*/ "should pass the value of mountOnload through",
 				&onloadv1alpha1.DevicePluginSpec{MountOnload: ptr.To(false)},
-				"-mountOnload=false",
+				"mountOnload: false",
 			),
 			Entry( /*It*/ "should pass the value of hostOnloadPath through",
 				&onloadv1alpha1.DevicePluginSpec{HostOnloadPath: ptr.To("foo")},
-				"-hostOnloadPath=foo",
+				"hostOnloadPath: foo",
 			),
 			Entry( /*It*/ "should pass the value of baseMountPath through",
 				&onloadv1alpha1.DevicePluginSpec{BaseMountPath: ptr.To("bar")},
-				"-baseMountPath=bar",
+				"baseMountPath: bar",
 			),
 			Entry( /*It*/ "should pass the value of binMountPath through",
 				&onloadv1alpha1.DevicePluginSpec{BinMountPath: ptr.To("baz")},
-				"-binMountPath=baz",
+				"binMountPath: baz",
 			),
 			Entry( /*It*/ "should pass the value of libMountPath through",
 				&onloadv1alpha1.DevicePluginSpec{LibMountPath: ptr.To("qux")},
-				"-libMountPath=qux",
+				"libMountPath: qux",
+			),
+			Entry( /*It*/ "should pass the value of extraLibMountPaths through",
+				&onloadv1alpha1.DevicePluginSpec{ExtraLibMountPaths: []string{"quux"}},
+				"extraLibMountPaths:\n- quux",
+			),
+			Entry( /*It*/ "should pass the value of lib32MountPath through",
+				&onloadv1alpha1.DevicePluginSpec{Lib32MountPath: ptr.To("")},
+				"lib32MountPath: \"\"",
+			),
+			Entry( /*It*/ "should pass the value of toolMounts through",
+				&onloadv1alpha1.DevicePluginSpec{ToolMounts: []string{"onload_stackdump"}},
+				"toolMounts:\n- onload_stackdump",
+			),
+			Entry( /*It*/ "should pass the value of perInterface through",
+				&onloadv1alpha1.DevicePluginSpec{PerInterface: ptr.To(true)},
+				"perInterface: true",
+			),
+			Entry( /*It*/ "should pass the value of cdi through",
+				&onloadv1alpha1.DevicePluginSpec{CDI: ptr.To(true)},
+				"cdi: true",
+			),
+			Entry( /*It*/ "should pass the value of tcpdirect through",
+				&onloadv1alpha1.DevicePluginSpec{TCPDirect: ptr.To(true)},
+				"tcpdirect: true",
+			),
+			Entry( /*It*/ "should pass the value of efvi through",
+				&onloadv1alpha1.DevicePluginSpec{EFVI: ptr.To(true)},
+				"efvi: true",
+			),
+			Entry( /*It*/ "should pass the value of resourceName through",
+				&onloadv1alpha1.DevicePluginSpec{ResourceName: ptr.To("amd.com/enterprise-onload")},
+				"resourceName: amd.com/enterprise-onload",
+			),
+			Entry( /*It*/ "should point at the mounted profile",
+				&onloadv1alpha1.DevicePluginSpec{Profile: ptr.To("onload-latency-profile")},
+				"profileDir: /etc/onload-profile",
 			),
 		)
 
@@ -924,4 +1832,179 @@ var _ = Describe("onload controller", func() {
 		)
 
 	})
+
+	Context("testing the native module loader", func() {
+		var (
+			onload        *onloadv1alpha1.Onload
+			testNamespace *corev1.Namespace
+			loader        *nativeModuleLoader
+			nodes         []*corev1.Node
+		)
+
+		BeforeEach(func() {
+			namespaceName, err := generateNamespaceName()
+			Expect(err).Should(Succeed())
+
+			testNamespace = &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: namespaceName},
+			}
+			Expect(k8sClient.Create(ctx, testNamespace)).Should(Succeed())
+
+			// The reconciler under test uses KMM, so keep it away from the
+			// test's Nodes. The loader is given a copy of the CR that selects
+			// them instead.
+			onload = &onloadv1alpha1.Onload{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "onload-test",
+					Namespace: namespaceName,
+				},
+				Spec: onloadv1alpha1.Spec{
+					Selector: map[string]string{
+						"native-module-loader-test": "unused",
+					},
+					Onload: onloadv1alpha1.OnloadSpec{
+						KernelMappings: []onloadv1alpha1.OnloadKernelMapping{
+							{
+								KernelModuleImage: "module:${KERNEL_FULL_VERSION}",
+								Regexp:            ".*",
+							},
+						},
+						UserImage: "image:tag",
+						Version:   "v1",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, onload)).Should(Succeed())
+			onload.Spec.Selector = map[string]string{
+				"native-module-loader-test": namespaceName,
+			}
+
+			loader = &nativeModuleLoader{Client: k8sClient, Scheme: k8sClient.Scheme()}
+			nodes = nil
+		})
+
+		AfterEach(func() {
+			for _, node := range nodes {
+				Expect(k8sClient.Delete(ctx, node)).Should(Succeed())
+			}
+			Expect(k8sClient.Delete(ctx, onload)).Should(Succeed())
+			Expect(k8sClient.Delete(ctx, testNamespace)).Should(Succeed())
+		})
+
+		// Creates a Node selected by the CR, running the given kernel, with
+		// the given version of the Onload module.
+		createNode := func(name, kernelVersion, version string) *corev1.Node {
+			versionLabel := kmmOnloadLabelName(onload.Name, onload.Namespace)
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: testNamespace.Name + "-" + name,
+					Labels: map[string]string{
+						"native-module-loader-test": testNamespace.Name,
+						kernelVersionLabel:          kernelVersion,
+						versionLabel:                version,
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, node)).Should(Succeed())
+			nodes = append(nodes, node)
+			return node
+		}
+
+		setVersion := func(node *corev1.Node, version string) {
+			nodeCopy := node.DeepCopy()
+			node.Labels[kmmOnloadLabelName(onload.Name, onload.Namespace)] = version
+			Expect(k8sClient.Patch(ctx, node, client.MergeFrom(nodeCopy))).Should(Succeed())
+		}
+
+		// Returns the module loader DaemonSets, keyed by the module and the
+		// kernel and module versions of the nodes they select.
+		getLoaders := func() []string {
+			daemonSets := appsv1.DaemonSetList{}
+			Expect(k8sClient.List(ctx, &daemonSets, client.InNamespace(onload.Namespace),
+				client.MatchingLabels(baseLabels(onload.Name, onload.Namespace, "module-loader")),
+			)).Should(Succeed())
+
+			loaders := []string{}
+			for _, ds := range daemonSets.Items {
+				Expect(ds.OwnerReferences).Should(ContainElement(HaveField("UID", onload.UID)))
+				module := ds.Labels[moduleNameLabel]
+				selector := ds.Spec.Template.Spec.NodeSelector
+				version := selector[kmmOnloadLabelName(onload.Name, onload.Namespace)]
+				if module == onload.Name+sfcModuleNameSuffix {
+					version = selector[kmmSFCLabelName(onload.Name, onload.Namespace)]
+				}
+				loaders = append(loaders, module+"/"+selector[kernelVersionLabel]+"/"+version)
+			}
+			return loaders
+		}
+
+		It("should label Nodes with their kernel version", func() {
+			node := createNode("a", "", "v1")
+			delete(node.Labels, kernelVersionLabel)
+			Expect(k8sClient.Update(ctx, node)).Should(Succeed())
+			node.Status.NodeInfo.KernelVersion = "5.14.0"
+			Expect(k8sClient.Status().Update(ctx, node)).Should(Succeed())
+
+			Expect(loader.CreateModules(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(node), node)).Should(Succeed())
+			Expect(node.Labels).Should(HaveKeyWithValue(kernelVersionLabel, "5.14.0"))
+		})
+
+		It("should create a DaemonSet for each kernel version", func() {
+			createNode("a", "5.14.0", "v1")
+			createNode("b", "5.14.0", "v1")
+			createNode("c", "6.1.0", "v1")
+
+			Expect(loader.CreateModules(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+			Expect(loader.CreateModules(ctx, onload)).Should(BeNil())
+
+			Expect(getLoaders()).Should(ConsistOf(
+				"onload-test-module/5.14.0/v1",
+				"onload-test-module/6.1.0/v1",
+			))
+		})
+
+		It("should keep the previous version loaded until every Node is upgraded", func() {
+			nodeA := createNode("a", "5.14.0", "v1")
+			nodeB := createNode("b", "5.14.0", "v1")
+
+			Expect(loader.CreateModules(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+
+			By("upgrading the CR")
+			onload.Spec.Onload.Version = "v2"
+			Expect(loader.CreateModules(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+			Expect(loader.UpdateModules(ctx, onload)).Should(BeNil())
+			Expect(getLoaders()).Should(ConsistOf(
+				"onload-test-module/5.14.0/v1",
+				"onload-test-module/5.14.0/v2",
+			))
+
+			By("upgrading one of the Nodes")
+			setVersion(nodeA, "v2")
+			Expect(loader.UpdateModules(ctx, onload)).Should(BeNil())
+			Expect(getLoaders()).Should(HaveLen(2))
+
+			By("upgrading the last Node")
+			setVersion(nodeB, "v2")
+			Expect(loader.UpdateModules(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+			Expect(loader.UpdateModules(ctx, onload)).Should(BeNil())
+			Expect(getLoaders()).Should(ConsistOf("onload-test-module/5.14.0/v2"))
+		})
+
+		It("should delete the DaemonSets of modules no longer used", func() {
+			createNode("a", "5.14.0", "v1")
+			onload.Spec.Onload.KernelMappings[0].SFC = &onloadv1alpha1.SFCSpec{}
+
+			Expect(loader.CreateModules(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+			Expect(getLoaders()).Should(ConsistOf(
+				"onload-test-module/5.14.0/v1",
+				"onload-test-sfcmod/5.14.0/v1",
+			))
+
+			onload.Spec.Onload.KernelMappings[0].SFC = nil
+			Expect(loader.UpdateModules(ctx, onload)).Should(Equal(&ctrl.Result{Requeue: true}))
+			Expect(getLoaders()).Should(ConsistOf("onload-test-module/5.14.0/v1"))
+		})
+	})
 })
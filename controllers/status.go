@@ -0,0 +1,292 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kmm "github.com/kubernetes-sigs/kernel-module-management/api/v1beta1"
+
+	onloadv1alpha1 "github.com/Xilinx-CNS/kubernetes-onload/api/v1alpha1"
+)
+
+// Condition types reported in the status of the Onload CR.
+const (
+	// conditionModulesSigned reports the state of the KMM jobs that sign the
+	// kernel modules, or that the module loader can't sign them. It is only
+	// present when signing is configured.
+	conditionModulesSigned = "ModulesSigned"
+
+	// conditionKernelMappingsMatched reports whether the kernel version of
+	// every selected node matches exactly one kernel mapping.
+	conditionKernelMappingsMatched = "KernelMappingsMatched"
+)
+
+// Labels set by KMM on the jobs it creates.
+const (
+	kmmModuleNameLabel = "kmm.node.kubernetes.io/module.name"
+	kmmJobTypeLabel    = "kmm.node.kubernetes.io/job-type"
+)
+
+// updateStatus writes the status of the Onload CR if it has changed.
+func (r *OnloadReconciler) updateStatus(ctx context.Context, onload *onloadv1alpha1.Onload,
+	oldStatus *onloadv1alpha1.Status,
+) error {
+	if equality.Semantic.DeepEqual(oldStatus, &onload.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, onload)
+}
+
+// Return true if any of the kernel mappings request signing.
+func onloadUsesSigning(onload *onloadv1alpha1.Onload) bool {
+	return slices.ContainsFunc(onload.Spec.Onload.KernelMappings,
+		func(kmap onloadv1alpha1.OnloadKernelMapping) bool {
+			return kmap.Sign != nil
+		})
+}
+
+func jobFailed(job batchv1.Job) bool {
+	return slices.ContainsFunc(job.Status.Conditions, func(c batchv1.JobCondition) bool {
+		return c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue
+	})
+}
+
+// signingCondition summarises the state of the KMM sign jobs. KMM only signs
+// the modules when the signed images don't exist yet, so without any jobs the
+// modules are only known to be signed once they have been loaded.
+func signingCondition(jobs []batchv1.Job, modulesLoaded bool) metav1.Condition {
+	failed := []string{}
+	active := false
+	for _, job := range jobs {
+		if jobFailed(job) {
+			failed = append(failed, job.Name)
+		} else if job.Status.Active > 0 {
+			active = true
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		return metav1.Condition{
+			Type:    conditionModulesSigned,
+			Status:  metav1.ConditionFalse,
+			Reason:  "SigningFailed",
+			Message: fmt.Sprintf("Kernel module sign job(s) failed: %s", strings.Join(failed, ", ")),
+		}
+	case active:
+		return metav1.Condition{
+			Type:    conditionModulesSigned,
+			Status:  metav1.ConditionUnknown,
+			Reason:  "SigningInProgress",
+			Message: "Waiting for kernel module sign job(s) to complete",
+		}
+	case len(jobs) == 0 && !modulesLoaded:
+		return metav1.Condition{
+			Type:    conditionModulesSigned,
+			Status:  metav1.ConditionUnknown,
+			Reason:  "SigningPending",
+			Message: "Waiting for KMM to sign the kernel modules",
+		}
+	default:
+		return metav1.Condition{
+			Type:   conditionModulesSigned,
+			Status: metav1.ConditionTrue,
+			Reason: "SigningSucceeded",
+		}
+	}
+}
+
+// signingUnsupportedCondition reports that the module loader ignores the sign
+// sections of the kernel mappings.
+func signingUnsupportedCondition() metav1.Condition {
+	return metav1.Condition{
+		Type:   conditionModulesSigned,
+		Status: metav1.ConditionFalse,
+		Reason: "SigningUnsupported",
+		Message: "The module loader does not sign kernel modules; " +
+			"the kernel module images must contain signed modules",
+	}
+}
+
+// onloadModuleLoaded returns true if KMM has loaded the Onload kernel module
+// on any node.
+func (r *OnloadReconciler) onloadModuleLoaded(ctx context.Context, onload *onloadv1alpha1.Onload,
+) (bool, error) {
+	module := kmm.Module{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      onload.Name + onloadModuleNameSuffix,
+		Namespace: onload.Namespace,
+	}, &module)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return module.Status.ModuleLoader.AvailableNumber > 0, nil
+}
+
+// updateSigningStatus reports the outcome of the KMM jobs that sign the Onload
+// and SFC kernel modules in the status of the Onload CR.
+func (r *OnloadReconciler) updateSigningStatus(ctx context.Context, onload *onloadv1alpha1.Onload) error {
+	log := log.FromContext(ctx)
+
+	oldStatus := onload.Status.DeepCopy()
+
+	if !onloadUsesSigning(onload) {
+		meta.RemoveStatusCondition(&onload.Status.Conditions, conditionModulesSigned)
+		return r.updateStatus(ctx, onload, oldStatus)
+	}
+
+	var condition metav1.Condition
+	if r.moduleLoader().SupportsSigning() {
+		moduleNames, err := labels.NewRequirement(kmmModuleNameLabel, selection.In, []string{
+			onload.Name + onloadModuleNameSuffix,
+			onload.Name + sfcModuleNameSuffix,
+		})
+		if err != nil {
+			return err
+		}
+		selector := labels.SelectorFromSet(labels.Set{kmmJobTypeLabel: "sign"}).Add(*moduleNames)
+
+		jobs := batchv1.JobList{}
+		err = r.List(ctx, &jobs, client.InNamespace(onload.Namespace),
+			client.MatchingLabelsSelector{Selector: selector})
+		if err != nil {
+			log.Error(err, "Failed to list sign Jobs")
+			return err
+		}
+
+		loaded, err := r.onloadModuleLoaded(ctx, onload)
+		if err != nil {
+			log.Error(err, "Failed to get Onload Module")
+			return err
+		}
+
+		condition = signingCondition(jobs.Items, loaded)
+	} else {
+		condition = signingUnsupportedCondition()
+	}
+	condition.ObservedGeneration = onload.Generation
+	meta.SetStatusCondition(&onload.Status.Conditions, condition)
+
+	return r.updateStatus(ctx, onload, oldStatus)
+}
+
+// kernelMappingMatches returns the number of kernel mappings whose regexp
+// matches the kernel version.
+func kernelMappingMatches(kmaps []onloadv1alpha1.OnloadKernelMapping, kernelVersion string,
+) (int, error) {
+	matches := 0
+	for _, kmap := range kmaps {
+		matched, err := regexp.MatchString(kmap.Regexp, kernelVersion)
+		if err != nil {
+			return 0, fmt.Errorf("invalid kernel mapping regexp %q: %w", kmap.Regexp, err)
+		}
+		if matched {
+			matches++
+		}
+	}
+	return matches, nil
+}
+
+// classifyNodes returns the names of the nodes whose kernel version matches no
+// kernel mapping and of those that match several. Nodes that have yet to
+// report a kernel version are ignored.
+func classifyNodes(kmaps []onloadv1alpha1.OnloadKernelMapping, nodes []corev1.Node,
+) (unmatched []string, ambiguous []string, err error) {
+	for _, node := range nodes {
+		kernelVersion := node.Status.NodeInfo.KernelVersion
+		if kernelVersion == "" {
+			continue
+		}
+
+		matches, err := kernelMappingMatches(kmaps, kernelVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case matches == 0:
+			unmatched = append(unmatched, node.Name)
+		case matches > 1:
+			ambiguous = append(ambiguous, node.Name)
+		}
+	}
+
+	slices.Sort(unmatched)
+	slices.Sort(ambiguous)
+	return unmatched, ambiguous, nil
+}
+
+// kernelMappingCondition summarises how the nodes matched the kernel mappings.
+func kernelMappingCondition(unmatched []string, ambiguous []string) metav1.Condition {
+	switch {
+	case len(unmatched) > 0:
+		return metav1.Condition{
+			Type:   conditionKernelMappingsMatched,
+			Status: metav1.ConditionFalse,
+			Reason: "NodesUnmatched",
+			Message: fmt.Sprintf("Kernel version of node(s) matches no kernel mapping: %s",
+				strings.Join(unmatched, ", ")),
+		}
+	case len(ambiguous) > 0:
+		return metav1.Condition{
+			Type:   conditionKernelMappingsMatched,
+			Status: metav1.ConditionFalse,
+			Reason: "NodesMatchMultipleMappings",
+			Message: fmt.Sprintf("Kernel version of node(s) matches multiple kernel mappings: %s",
+				strings.Join(ambiguous, ", ")),
+		}
+	default:
+		return metav1.Condition{
+			Type:   conditionKernelMappingsMatched,
+			Status: metav1.ConditionTrue,
+			Reason: "AllNodesMatched",
+		}
+	}
+}
+
+// updateKernelMappingStatus reports the selected nodes whose kernel version
+// does not match exactly one kernel mapping in the status of the Onload CR.
+func (r *OnloadReconciler) updateKernelMappingStatus(ctx context.Context, onload *onloadv1alpha1.Onload) error {
+	log := log.FromContext(ctx)
+
+	oldStatus := onload.Status.DeepCopy()
+
+	nodes, err := r.listNodesWithLabels(ctx, labels.FormatLabels(onload.Spec.Selector))
+	if err != nil {
+		return err
+	}
+
+	unmatched, ambiguous, err := classifyNodes(onload.Spec.Onload.KernelMappings, nodes.Items)
+	if err != nil {
+		log.Error(err, "Failed to match nodes against kernel mappings")
+		return err
+	}
+
+	onload.Status.Onload.UnmatchedNodes = unmatched
+	onload.Status.Onload.AmbiguousNodes = ambiguous
+
+	condition := kernelMappingCondition(unmatched, ambiguous)
+	condition.ObservedGeneration = onload.Generation
+	meta.SetStatusCondition(&onload.Status.Conditions, condition)
+
+	return r.updateStatus(ctx, onload, oldStatus)
+}
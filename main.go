@@ -46,11 +46,14 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var moduleLoaderName string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&moduleLoaderName, "module-loader", controllers.ModuleLoaderKMM,
+		"The backend used to load kernel modules onto nodes. One of \"kmm\" (Kernel Module Management) or \"native\".")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -95,10 +98,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	moduleLoader, err := controllers.NewModuleLoader(moduleLoaderName,
+		mgr.GetClient(), mgr.GetScheme())
+	if err != nil {
+		setupLog.Error(err, "unable to create module loader")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.OnloadReconciler{
 		Client:            mgr.GetClient(),
 		Scheme:            mgr.GetScheme(),
 		DevicePluginImage: devicePluginImage,
+		ModuleLoader:      moduleLoader,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Onload")
 		os.Exit(1)
@@ -0,0 +1,279 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+// The node annotation listing the allocations of onload devices on the node.
+// The Onload Operator reads it to find the pods using onload.
+const allocationsAnnotation = "onload.amd.com/allocations"
+
+// How often to ask the kubelet which pods hold our devices. The PodResources
+// API can't be watched.
+const allocationPollInterval = 10 * time.Second
+
+// The path that the allocations are served on, alongside the device plugin's
+// state. They name pods, so aren't served with the metrics on every interface.
+const allocationsPath = "/allocations"
+
+// Allocation describes the onload devices allocated to a container.
+type Allocation struct {
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod"`
+	Container string   `json:"container"`
+	Resource  string   `json:"resource"`
+	DeviceIDs []string `json:"deviceIDs"`
+}
+
+// ownsResource returns true if name is the shared resource, one of the
+// resources restricted to an interface or an API resource advertised by the
+// device plugin. Only the exact names are matched, so that another flavour's
+// resources, which may share a prefix with ours, aren't.
+func (manager *NicManager) ownsResource(name string) bool {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if name == manager.config.ResourceName {
+		return true
+	}
+	for key := range manager.resources {
+		if manager.resourceNameFor(key) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the allocations of onload devices in a PodResources List response,
+// in a stable order, given whether a resource is ours.
+func allocationsOf(resp *podresourcesapi.ListPodResourcesResponse, isOurs func(string) bool) []Allocation {
+	allocations := []Allocation{}
+	for _, pod := range resp.GetPodResources() {
+		for _, container := range pod.GetContainers() {
+			for _, devices := range container.GetDevices() {
+				if !isOurs(devices.GetResourceName()) {
+					continue
+				}
+				ids := slices.Clone(devices.GetDeviceIds())
+				slices.Sort(ids)
+				allocations = append(allocations, Allocation{
+					Namespace: pod.GetNamespace(),
+					Pod:       pod.GetName(),
+					Container: container.GetName(),
+					Resource:  devices.GetResourceName(),
+					DeviceIDs: ids,
+				})
+			}
+		}
+	}
+	slices.SortFunc(allocations, func(a, b Allocation) int {
+		keyA := []string{a.Namespace, a.Pod, a.Container, a.Resource}
+		keyB := []string{b.Namespace, b.Pod, b.Container, b.Resource}
+		return slices.Compare(keyA, keyB)
+	})
+	return allocations
+}
+
+// listAllocations asks the kubelet, over its PodResources socket, which
+// containers hold the devices of our resources, given whether a resource is
+// ours.
+func listAllocations(ctx context.Context, sockPath string, isOurs func(string) bool) ([]Allocation, error) {
+	conn, err := grpcDial(ctx, sockPath, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kubelet pod resources socket %s (%w)", sockPath, err)
+	}
+	defer conn.Close()
+
+	client := podresourcesapi.NewPodResourcesListerClient(conn)
+	resp, err := client.List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod resources (%w)", err)
+	}
+	return allocationsOf(resp, isOurs), nil
+}
+
+// allocationPublisher makes the allocations on the node visible outside it.
+type allocationPublisher interface {
+	publishAllocations(ctx context.Context, allocations []Allocation) error
+}
+
+// nodeAnnotator publishes the allocations as an annotation on the node.
+type nodeAnnotator struct {
+	client   kubernetes.Interface
+	nodeName string
+}
+
+func (annotator nodeAnnotator) publishAllocations(ctx context.Context, allocations []Allocation) error {
+	value, err := json.Marshal(allocations)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{allocationsAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = annotator.client.CoreV1().Nodes().Patch(ctx, annotator.nodeName,
+		types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate node %s (%w)", annotator.nodeName, err)
+	}
+	return nil
+}
+
+// Initialises tracking of the allocations, if enabled, and publishing them on
+// the node if its name is known and the device plugin is running in a cluster.
+func (manager *NicManager) initAllocations() {
+	sockPath := manager.config.PodResourcesSocket
+	if sockPath == "" {
+		return
+	}
+	manager.listAllocations = func(ctx context.Context) ([]Allocation, error) {
+		return listAllocations(ctx, sockPath, manager.ownsResource)
+	}
+
+	nodeName := manager.config.NodeName
+	if nodeName == "" {
+		return
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		glog.Warningf("Not annotating node %s with allocations (%v)", nodeName, err)
+		return
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Warningf("Not annotating node %s with allocations (%v)", nodeName, err)
+		return
+	}
+	manager.publisher = nodeAnnotator{client: client, nodeName: nodeName}
+}
+
+// getAllocations returns the allocations last found.
+func (manager *NicManager) getAllocations() []Allocation {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.allocations
+}
+
+// updateAllocations asks the kubelet which containers hold onload devices and
+// publishes any change. The allocations are only updated once published, so
+// that publishing is retried on failure.
+func (manager *NicManager) updateAllocations(ctx context.Context) error {
+	allocations, err := manager.listAllocations(ctx)
+	if err != nil {
+		return err
+	}
+
+	manager.mu.Lock()
+	unchanged := manager.allocations != nil && reflect.DeepEqual(allocations, manager.allocations)
+	manager.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	if manager.publisher != nil {
+		err = manager.publisher.publishAllocations(ctx, allocations)
+		if err != nil {
+			return err
+		}
+	}
+	glog.Infof("Onload allocations: %+v", allocations)
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.allocations = allocations
+	return nil
+}
+
+// allocationsLogger logs the failures to update the allocations. Not being
+// allowed to annotate the node won't fix itself, so is only logged once until
+// an update succeeds, rather than on every poll.
+type allocationsLogger struct {
+	forbidden bool
+}
+
+// log logs a failure to update the allocations, returning true if it was
+// logged, or notes that the update succeeded if err is nil.
+func (logger *allocationsLogger) log(err error) bool {
+	if err == nil {
+		logger.forbidden = false
+		return false
+	}
+	if apierrors.IsForbidden(err) {
+		if logger.forbidden {
+			glog.V(2).Infof("Failed to update allocations (%v)", err)
+			return false
+		}
+		logger.forbidden = true
+		glog.Warningf("Failed to update allocations, the device plugin's service account "+
+			"needs to get and patch nodes (%v)", err)
+		return true
+	}
+	glog.Warningf("Failed to update allocations (%v)", err)
+	return true
+}
+
+// trackAllocations keeps the allocations up to date until ctx is cancelled.
+func (manager *NicManager) trackAllocations(ctx context.Context) {
+	ticker := time.NewTicker(allocationPollInterval)
+	defer ticker.Stop()
+
+	logger := allocationsLogger{}
+	for {
+		err := manager.updateAllocations(ctx)
+		if ctx.Err() == nil {
+			logger.log(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// finalAllocations updates the allocations once the RPC servers have stopped.
+// No more devices can then be allocated, so the published allocations stay
+// complete while the device plugin isn't running.
+func (manager *NicManager) finalAllocations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := manager.updateAllocations(ctx)
+	if err != nil {
+		glog.Warningf("Failed to update allocations on stopping (%v)", err)
+	}
+}
+
+// allocationsHandler returns a handler serving the allocations as JSON.
+func (manager *NicManager) allocationsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allocations := manager.getAllocations()
+		if allocations == nil {
+			allocations = []Allocation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(allocations)
+		if err != nil {
+			glog.Warningf("Failed to serve allocations (%v)", err)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+)
+
+// fakePodResources implements the kubelet's PodResources List service.
+type fakePodResources struct {
+	podresourcesapi.UnimplementedPodResourcesListerServer
+	resp *podresourcesapi.ListPodResourcesResponse
+}
+
+func (fake *fakePodResources) List(
+	context.Context,
+	*podresourcesapi.ListPodResourcesRequest,
+) (*podresourcesapi.ListPodResourcesResponse, error) {
+	return fake.resp, nil
+}
+
+// failingPublisher fails to publish the allocations.
+type failingPublisher struct{}
+
+func (failingPublisher) publishAllocations(context.Context, []Allocation) error {
+	return errors.New("publishing failed")
+}
+
+var _ = Describe("Testing allocation tracking", func() {
+	var (
+		manager  *NicManager
+		sockPath string
+		kubelet  *fakePodResources
+	)
+
+	podResources := func(namespace, name, container, resource string, ids ...string) *podresourcesapi.PodResources {
+		return &podresourcesapi.PodResources{
+			Namespace: namespace,
+			Name:      name,
+			Containers: []*podresourcesapi.ContainerResources{
+				{
+					Name: container,
+					Devices: []*podresourcesapi.ContainerDevices{
+						{ResourceName: resource, DeviceIds: ids},
+					},
+				},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		sockPath = filepath.Join(GinkgoT().TempDir(), "kubelet.sock")
+		listener, err := net.Listen("unix", sockPath)
+		Expect(err).Should(Succeed())
+
+		kubelet = &fakePodResources{resp: &podresourcesapi.ListPodResourcesResponse{
+			PodResources: []*podresourcesapi.PodResources{
+				podResources("default", "server", "app", "amd.com/onload", "sfc-3", "sfc-1"),
+				podResources("default", "client", "app", "amd.com/onload-enp2s0f0", "sfc-0"),
+				podResources("default", "gpu", "app", "nvidia.com/gpu", "gpu-0"),
+				// Another flavour's resource, sharing a prefix with ours.
+				podResources("default", "other", "app", "amd.com/onload-enterprise", "sfc-0"),
+			},
+		}}
+		server := grpc.NewServer()
+		podresourcesapi.RegisterPodResourcesListerServer(server, kubelet)
+		// Serve fails if the server is stopped before it starts serving, so its
+		// error isn't checked.
+		go server.Serve(listener) //nolint:errcheck
+		DeferCleanup(server.Stop)
+
+		config := newFakeHost()
+		config.PodResourcesSocket = sockPath
+		config.PerInterface = true
+		manager, err = NewNicManager(config)
+		Expect(err).Should(Succeed())
+	})
+
+	expected := []Allocation{
+		{Namespace: "default", Pod: "client", Container: "app",
+			Resource: "amd.com/onload-enp2s0f0", DeviceIDs: []string{"sfc-0"}},
+		{Namespace: "default", Pod: "server", Container: "app",
+			Resource: "amd.com/onload", DeviceIDs: []string{"sfc-1", "sfc-3"}},
+	}
+
+	It("should list the containers holding onload devices", func() {
+		Expect(listAllocations(context.Background(), sockPath, manager.ownsResource)).Should(Equal(expected))
+	})
+
+	It("should annotate the node with the allocations when they change", func() {
+		clientset := fake.NewSimpleClientset(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		})
+		manager.publisher = nodeAnnotator{client: clientset, nodeName: "node"}
+
+		Expect(manager.updateAllocations(context.Background())).Should(Succeed())
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+		Expect(err).Should(Succeed())
+		published := []Allocation{}
+		Expect(json.Unmarshal([]byte(node.Annotations[allocationsAnnotation]), &published)).
+			Should(Succeed())
+		Expect(published).Should(Equal(expected))
+
+		clientset.ClearActions()
+		Expect(manager.updateAllocations(context.Background())).Should(Succeed())
+		Expect(clientset.Actions()).Should(BeEmpty())
+
+		kubelet.resp = &podresourcesapi.ListPodResourcesResponse{}
+		Expect(manager.updateAllocations(context.Background())).Should(Succeed())
+		node, err = clientset.CoreV1().Nodes().Get(context.Background(), "node", metav1.GetOptions{})
+		Expect(err).Should(Succeed())
+		Expect(node.Annotations).Should(HaveKeyWithValue(allocationsAnnotation, "[]"))
+	})
+
+	It("should retry publishing the allocations", func() {
+		manager.publisher = failingPublisher{}
+		Expect(manager.updateAllocations(context.Background())).ShouldNot(Succeed())
+		Expect(manager.getAllocations()).Should(BeNil())
+
+		manager.publisher = nil
+		Expect(manager.updateAllocations(context.Background())).Should(Succeed())
+		Expect(manager.getAllocations()).Should(Equal(expected))
+	})
+
+	It("should only log being forbidden from annotating the node once", func() {
+		clientset := fake.NewSimpleClientset(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		})
+		clientset.PrependReactor("patch", "nodes",
+			func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(
+					schema.GroupResource{Resource: "nodes"}, "node", errors.New("denied"))
+			})
+		manager.publisher = nodeAnnotator{client: clientset, nodeName: "node"}
+		logger := allocationsLogger{}
+
+		err := manager.updateAllocations(context.Background())
+		Expect(apierrors.IsForbidden(err)).Should(BeTrue())
+		Expect(logger.log(err)).Should(BeTrue())
+		Expect(logger.log(manager.updateAllocations(context.Background()))).Should(BeFalse())
+		Expect(logger.log(errors.New("other failure"))).Should(BeTrue())
+		Expect(logger.log(manager.updateAllocations(context.Background()))).Should(BeFalse())
+
+		manager.publisher = nil
+		Expect(logger.log(manager.updateAllocations(context.Background()))).Should(BeFalse())
+		manager.allocations = nil
+		manager.publisher = nodeAnnotator{client: clientset, nodeName: "node"}
+		Expect(logger.log(manager.updateAllocations(context.Background()))).Should(BeTrue())
+	})
+
+	It("should serve the allocations alongside the state", func() {
+		Expect(manager.updateAllocations(context.Background())).Should(Succeed())
+
+		recorder := httptest.NewRecorder()
+		manager.debugMux().ServeHTTP(recorder,
+			httptest.NewRequest(http.MethodGet, allocationsPath, nil))
+		Expect(recorder.Code).Should(Equal(http.StatusOK))
+		served := []Allocation{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &served)).Should(Succeed())
+		Expect(served).Should(Equal(expected))
+	})
+
+	It("should not track allocations unless enabled", func() {
+		config := newFakeHost()
+		config.PodResourcesSocket = ""
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+		Expect(manager.listAllocations).Should(BeNil())
+	})
+})
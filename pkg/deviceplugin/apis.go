@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/golang/glog"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// apiResource is a resource giving containers one of onload's lower level
+// APIs, such as TCPDirect, rather than accelerating them with onload.
+// Applications link against the API's libraries themselves, so LD_PRELOAD
+// isn't set.
+type apiResource struct {
+	// name is the name of the resource, which is also its key in the
+	// NicManager's resources. It can't be an interface name, as it contains
+	// a '/'.
+	name string
+	// cdiDevice is the name of the CDI device giving containers the API.
+	cdiDevice string
+	// libraries are the shared libraries implementing the API.
+	libraries []string
+	// devices are the device nodes the API uses.
+	devices []string
+	// controlPlane is true if the API uses onload's control plane.
+	controlPlane bool
+}
+
+var (
+	tcpdirectResource = apiResource{
+		name:      "amd.com/tcpdirect",
+		cdiDevice: "tcpdirect",
+		libraries: []string{"libonload_zf.so"},
+		devices:   []string{"/dev/onload", "/dev/sfc_char"},
+		// TCPDirect looks up routes using onload's control plane.
+		controlPlane: true,
+	}
+	efviResource = apiResource{
+		name:      "amd.com/efvi",
+		cdiDevice: "efvi",
+		libraries: []string{"libciul1.so"},
+		devices:   []string{"/dev/sfc_char"},
+	}
+	apiResources = []apiResource{tcpdirectResource, efviResource}
+)
+
+// Returns the API resource with the given key in the NicManager's
+// resources, if it is one.
+func apiResourceFor(key string) (apiResource, bool) {
+	i := slices.IndexFunc(apiResources, func(res apiResource) bool { return res.name == key })
+	if i < 0 {
+		return apiResource{}, false
+	}
+	return apiResources[i], true
+}
+
+// Returns the API resources that the config enables.
+func enabledAPIResources(config NicManagerConfig) []apiResource {
+	resources := []apiResource{}
+	if config.TCPDirect {
+		resources = append(resources, tcpdirectResource)
+	}
+	if config.EFVI {
+		resources = append(resources, efviResource)
+	}
+	return resources
+}
+
+// checkAPIHealth returns why pods can't currently use the API on this node,
+// or nil if they can. The API's libraries must have been installed from the
+// user image.
+func (manager *NicManager) checkAPIHealth(res apiResource, nics []Nic) error {
+	err := manager.checkNics(nics)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range res.devices {
+		if _, err := os.Stat(filepath.Join(manager.config.DevRoot, device)); err != nil {
+			return fmt.Errorf("device %s is missing (%w)", device, err)
+		}
+	}
+
+	dir := path.Join(manager.config.HostPathPrefix, hostLib64path)
+	for _, library := range res.libraries {
+		versions, err := findLibraryVersions(library, manager.config.HostPathPrefix, dir)
+		if err == nil && len(versions) == 0 {
+			err = os.ErrNotExist
+		}
+		if err != nil {
+			return fmt.Errorf("library %s is missing (%w)", library, err)
+		}
+	}
+
+	return manager.checkVersions()
+}
+
+// Initialises the response to Allocate for each enabled API resource,
+// replacing any previous ones.
+func (manager *NicManager) initAPIResponses() {
+	manager.apiResponses = map[string]*pluginapi.ContainerAllocateResponse{}
+	for _, res := range enabledAPIResources(manager.config) {
+		resp := &pluginapi.ContainerAllocateResponse{
+			Envs:    map[string]string{},
+			Mounts:  []*pluginapi.Mount{},
+			Devices: []*pluginapi.DeviceSpec{},
+		}
+		for _, device := range res.devices {
+			resp.Devices = append(resp.Devices, newDeviceSpec(device))
+		}
+		for _, library := range res.libraries {
+			mounts, err := manager.findLibraryMounts(library)
+			if err != nil {
+				glog.Warningf("Failed to add library mount for %s (%v)", library, err)
+				continue
+			}
+			resp.Mounts = append(resp.Mounts, mounts...)
+		}
+		manager.apiResponses[res.name] = resp
+	}
+}
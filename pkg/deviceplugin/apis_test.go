@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("Testing API resources", func() {
+	var config NicManagerConfig
+
+	BeforeEach(func() {
+		config = newFakeHost()
+		config.MaxPodsPerNode = 2
+		config.TCPDirect = true
+		config.EFVI = true
+	})
+
+	installLibrary := func(name string) {
+		Expect(os.WriteFile(path.Join(config.HostPathPrefix, hostLib64path, name), []byte{}, 0644)).
+			Should(Succeed())
+	}
+
+	newManager := func() *NicManager {
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+		return manager
+	}
+
+	It("should only advertise the resources enabled", func() {
+		config.EFVI = false
+		manager := newManager()
+		Expect(manager.resources).Should(HaveLen(2))
+		Expect(manager.resources).Should(HaveKey(""))
+		Expect(manager.resources).Should(HaveKey("amd.com/tcpdirect"))
+	})
+
+	It("should name the resources and their sockets", func() {
+		manager := newManager()
+		rpc := NewRPCServer(manager, "amd.com/tcpdirect")
+		Expect(rpc.resourceName).Should(Equal("amd.com/tcpdirect"))
+		Expect(rpc.listenSockPath).Should(HaveSuffix("/tcpdirect-sfc-deviceplugin.sock"))
+		Expect(manager.resourceNameFor("amd.com/efvi")).Should(Equal("amd.com/efvi"))
+		Expect(manager.ownsResource("amd.com/efvi")).Should(BeTrue())
+	})
+
+	It("should be unhealthy until the user image provides the libraries", func() {
+		manager := newManager()
+		devices, _ := manager.watchDevices("amd.com/tcpdirect")
+		Expect(devices).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+
+		installLibrary("libonload_zf.so.1")
+		Expect(manager.updateHealth()).Should(BeTrue())
+		devices, _ = manager.watchDevices("amd.com/tcpdirect")
+		Expect(devices).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+		devices, _ = manager.watchDevices("amd.com/efvi")
+		Expect(devices).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+	})
+
+	It("should give containers the libraries and devices without LD_PRELOAD", func() {
+		installLibrary("libonload_zf.so.1")
+		installLibrary("libciul1.so.1")
+		manager := newManager()
+
+		resp := allocate(manager, "amd.com/tcpdirect")
+		Expect(resp.Envs).Should(BeEmpty())
+		Expect(resp.Devices).Should(ConsistOf(
+			HaveField("ContainerPath", "/dev/onload"),
+			HaveField("ContainerPath", "/dev/sfc_char"),
+		))
+		Expect(resp.Mounts).Should(ConsistOf(
+			HaveField("ContainerPath", "/opt/onload/usr/lib64/libonload_zf.so.1"),
+		))
+
+		resp = allocate(manager, "amd.com/efvi")
+		Expect(resp.Envs).Should(BeEmpty())
+		Expect(resp.Devices).Should(ConsistOf(HaveField("ContainerPath", "/dev/sfc_char")))
+		Expect(resp.Mounts).Should(ConsistOf(
+			HaveField("ContainerPath", "/opt/onload/usr/lib64/libciul1.so.1"),
+		))
+	})
+
+	It("should share the resources between interfaces in per-interface mode", func() {
+		config.PerInterface = true
+		manager := newManager()
+		Expect(manager.resources).Should(HaveKey("enp2s0f0"))
+		Expect(manager.resources).Should(HaveKey("amd.com/tcpdirect"))
+		Expect(manager.resources).ShouldNot(HaveKey(""))
+
+		resp := allocate(manager, "amd.com/tcpdirect")
+		Expect(resp.Envs).ShouldNot(HaveKey("EF_INTERFACE_WHITELIST"))
+	})
+
+	It("should describe the resources in the CDI spec", func() {
+		installLibrary("libonload_zf.so.1")
+		config.CDI = true
+		config.CDISpecDir = filepath.Join(GinkgoT().TempDir(), "cdi")
+		manager := newManager()
+
+		spec := manager.makeCDISpec()
+		Expect(spec.Devices).Should(HaveLen(3))
+		Expect(spec.Devices[1].Name).Should(Equal("tcpdirect"))
+		Expect(spec.Devices[1].ContainerEdits.Env).Should(BeEmpty())
+		Expect(spec.Devices[1].ContainerEdits.Mounts).Should(ConsistOf(
+			HaveField("ContainerPath", "/opt/onload/usr/lib64/libonload_zf.so.1"),
+		))
+
+		resp := allocate(manager, "amd.com/tcpdirect")
+		Expect(resp.CDIDevices).Should(ConsistOf(HaveField("Name", "amd.com/onload=tcpdirect")))
+		Expect(resp.Mounts).Should(BeEmpty())
+	})
+})
@@ -4,54 +4,73 @@ package deviceplugin
 
 import (
 	"context"
+	"fmt"
+	"maps"
 	"strings"
-	"time"
 
 	"github.com/golang/glog"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
 // GetDevicePluginOptions is used by the kubernetes device manager to check
-// which optional features we implement. Since we don't use either we can just
-// return false for both, which should prevent any headaches if the device
-// plugin gets requested to do something it doesn't support.
+// which optional features we implement. PreStartContainer lets us check that
+// onload is ready before each container starts, and GetPreferredAllocation
+// lets the kubelet keep a container's devices on the same NUMA node.
 func (rpc *RPCServer) GetDevicePluginOptions(
 	context.Context,
 	*pluginapi.Empty,
 ) (*pluginapi.DevicePluginOptions, error) {
 	return &pluginapi.DevicePluginOptions{
-		PreStartRequired:                false,
-		GetPreferredAllocationAvailable: false,
+		PreStartRequired:                true,
+		GetPreferredAllocationAvailable: true,
 	}, nil
 }
 
-// PreStartContainer is not used here, but required by the device plugin API
+// PreStartContainer is called by the kubelet before starting each container
+// given our devices. Failing stops the container from starting with our
+// error, rather than it starting without being able to use onload.
 func (rpc *RPCServer) PreStartContainer(
-	context.Context,
-	*pluginapi.PreStartContainerRequest,
+	ctx context.Context,
+	req *pluginapi.PreStartContainerRequest,
 ) (*pluginapi.PreStartContainerResponse, error) {
+	glog.Infof("PreStartContainer: %s", strings.Join(req.DevicesIDs, ","))
+	err := rpc.manager.checkReady(rpc.iface)
+	if err != nil {
+		preStartFailuresTotal.WithLabelValues(rpc.resourceName).Inc()
+		glog.Warningf("Container given %s is not ready to start (%v)", rpc.resourceName, err)
+		return nil, fmt.Errorf("%s is not ready (%w)", rpc.resourceName, err)
+	}
 	return &pluginapi.PreStartContainerResponse{}, nil
 }
 
 // ListAndWatch is called by the kubelet at start of day;
-// loops forever sending status updates periodically.
-// NOTE: In this version of the device plugin the state we report never
-// changes, as the device plugin exits with an error if the set of
-// visible NICs changes.
+// loops until the server stops, sending the devices again as soon as their
+// health changes. Any number of streams may be open at once.
 func (rpc *RPCServer) ListAndWatch(
 	emtpy *pluginapi.Empty,
 	stream pluginapi.DevicePlugin_ListAndWatchServer,
 ) error {
 	glog.Info("ListAndWatch")
+	stopping := rpc.stoppingChan()
 	for {
-		rpc.manager.CheckNics()
+		devices, changed := rpc.manager.watchDevices(rpc.iface)
 		resp := &pluginapi.ListAndWatchResponse{}
-		resp.Devices = rpc.manager.devices
+		resp.Devices = devices
 		err := stream.Send(resp)
 		if err != nil {
 			glog.Errorf("ListAndWatch failed send (%v)", err)
+			return err
+		}
+		rpc.manager.recordSend(rpc.iface)
+		select {
+		case <-stream.Context().Done():
+			glog.Info("ListAndWatch stream closed")
+			return nil
+		case <-stopping:
+			glog.Info("ListAndWatch stopping")
+			return nil
+		case <-changed:
 		}
-		time.Sleep(time.Second * 60)
 	}
 }
 
@@ -63,26 +82,69 @@ func (rpc *RPCServer) Allocate(
 	reqs *pluginapi.AllocateRequest,
 ) (*pluginapi.AllocateResponse, error) {
 	glog.Info("Allocate")
+	allocationsTotal.WithLabelValues(rpc.resourceName).Inc()
 
 	resps := pluginapi.AllocateResponse{}
 
 	for _, req := range reqs.ContainerRequests {
 		devIDs := strings.Join(req.DevicesIDs, ",")
 		glog.Infof("  Devices: %s", devIDs)
-		resp := pluginapi.ContainerAllocateResponse{
-			Envs:    rpc.manager.envs,
-			Devices: rpc.manager.deviceFiles,
-			Mounts:  rpc.manager.mounts,
-		}
-		resps.ContainerResponses = append(resps.ContainerResponses, &resp)
+		resp := rpc.manager.containerAllocateResponse(rpc.iface)
+		resps.ContainerResponses = append(resps.ContainerResponses, resp)
+		containerAllocationsTotal.WithLabelValues(rpc.resourceName).Inc()
 	}
 	return &resps, nil
 }
 
-// GetPreferredAllocation is not used here, but required by the device plugin API
+// Returns the response to Allocate for a container, restricting onload to
+// the given interface unless it is "", or giving the API resource with that
+// name.
+func (manager *NicManager) containerAllocateResponse(iface string) *pluginapi.ContainerAllocateResponse {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if res, ok := apiResourceFor(iface); ok {
+		if manager.useCDI {
+			return &pluginapi.ContainerAllocateResponse{
+				CDIDevices: []*pluginapi.CDIDevice{{Name: manager.cdiQualifiedName(res.cdiDevice)}},
+			}
+		}
+		return manager.apiResponses[res.name]
+	}
+
+	resp := &pluginapi.ContainerAllocateResponse{}
+
+	if manager.useCDI {
+		resp.CDIDevices = []*pluginapi.CDIDevice{{Name: manager.cdiQualifiedName(cdiDeviceName)}}
+	} else {
+		resp.Envs = manager.envs
+		resp.Devices = manager.deviceFiles
+		resp.Mounts = manager.mounts
+	}
+
+	if iface != "" {
+		envs := map[string]string{}
+		maps.Copy(envs, resp.Envs)
+		envs[interfaceWhitelistEnv] = iface
+		resp.Envs = envs
+	}
+	return resp
+}
+
+// GetPreferredAllocation is called by the kubelet to choose which of the
+// available devices to allocate to each container, preferring devices local
+// to as few NUMA nodes as possible.
 func (rpc *RPCServer) GetPreferredAllocation(
-	context.Context,
-	*pluginapi.PreferredAllocationRequest,
+	ctx context.Context,
+	reqs *pluginapi.PreferredAllocationRequest,
 ) (*pluginapi.PreferredAllocationResponse, error) {
-	return &pluginapi.PreferredAllocationResponse{}, nil
+	devices, _ := rpc.manager.watchDevices(rpc.iface)
+	resps := &pluginapi.PreferredAllocationResponse{}
+	for _, req := range reqs.ContainerRequests {
+		ids := preferredAllocation(devices, req)
+		glog.Infof("Preferred allocation: %s", strings.Join(ids, ","))
+		resps.ContainerResponses = append(resps.ContainerResponses,
+			&pluginapi.ContainerPreferredAllocationResponse{DeviceIDs: ids})
+	}
+	return resps, nil
 }
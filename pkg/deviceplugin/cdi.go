@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/golang/glog"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// The version of the Container Device Interface spec we write.
+	cdiVersion = "0.5.0"
+	// The name of the CDI device giving a container everything it needs to
+	// run onload.
+	cdiDeviceName = "onload"
+)
+
+// The subset of the Container Device Interface spec that we use.
+// See https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+type cdiSpec struct {
+	Version string      `json:"cdiVersion"`
+	Kind    string      `json:"kind"`
+	Devices []cdiDevice `json:"devices"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	Env         []string        `json:"env,omitempty"`
+	DeviceNodes []cdiDeviceNode `json:"deviceNodes,omitempty"`
+	Mounts      []cdiMount      `json:"mounts,omitempty"`
+}
+
+type cdiDeviceNode struct {
+	Path        string `json:"path"`
+	HostPath    string `json:"hostPath,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+type cdiMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Options       []string `json:"options,omitempty"`
+}
+
+// Returns the kind of our CDI devices, which must be "vendor/class". It is
+// the name of the shared resource, so that each flavour of onload has its
+// own.
+func (manager *NicManager) cdiKind() string {
+	return manager.config.ResourceName
+}
+
+// Returns the name of the CDI spec file written in the spec directory.
+func (manager *NicManager) cdiSpecFileName() string {
+	return strings.ReplaceAll(manager.cdiKind(), "/", "-") + ".yaml"
+}
+
+// Returns the fully qualified name of the CDI device with the given name.
+func (manager *NicManager) cdiQualifiedName(name string) string {
+	return manager.cdiKind() + "=" + name
+}
+
+// Returns the CDI container edits giving the same device nodes, mounts and
+// environment as an Allocate response.
+func cdiEditsOf(
+	deviceFiles []*pluginapi.DeviceSpec,
+	mounts []*pluginapi.Mount,
+	envs map[string]string,
+) cdiContainerEdits {
+	edits := cdiContainerEdits{}
+
+	for _, device := range deviceFiles {
+		edits.DeviceNodes = append(edits.DeviceNodes, cdiDeviceNode{
+			Path:        device.ContainerPath,
+			HostPath:    device.HostPath,
+			Permissions: device.Permissions,
+		})
+	}
+
+	for _, mount := range mounts {
+		options := []string{"bind"}
+		if mount.ReadOnly {
+			options = append(options, "ro")
+		}
+		edits.Mounts = append(edits.Mounts, cdiMount{
+			HostPath:      mount.HostPath,
+			ContainerPath: mount.ContainerPath,
+			Options:       options,
+		})
+	}
+
+	for name, value := range envs {
+		edits.Env = append(edits.Env, fmt.Sprintf("%s=%s", name, value))
+	}
+	slices.Sort(edits.Env)
+	return edits
+}
+
+// makeCDISpec describes the same device nodes, mounts and environment as are
+// otherwise returned by Allocate, with a device for onload and for each
+// enabled API resource.
+func (manager *NicManager) makeCDISpec() cdiSpec {
+	devices := []cdiDevice{{
+		Name:           cdiDeviceName,
+		ContainerEdits: cdiEditsOf(manager.deviceFiles, manager.mounts, manager.envs),
+	}}
+	for _, res := range enabledAPIResources(manager.config) {
+		resp := manager.apiResponses[res.name]
+		devices = append(devices, cdiDevice{
+			Name:           res.cdiDevice,
+			ContainerEdits: cdiEditsOf(resp.Devices, resp.Mounts, resp.Envs),
+		})
+	}
+
+	return cdiSpec{
+		Version: cdiVersion,
+		Kind:    manager.cdiKind(),
+		Devices: devices,
+	}
+}
+
+// writeCDISpec writes the CDI spec into the spec directory, replacing any
+// previous one atomically so that the runtime never reads a partial spec.
+func (manager *NicManager) writeCDISpec() error {
+	bytes, err := yaml.Marshal(manager.makeCDISpec())
+	if err != nil {
+		return fmt.Errorf("failed to marshal CDI spec (%w)", err)
+	}
+
+	dir := manager.config.CDISpecDir
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create CDI spec directory %s (%w)", dir, err)
+	}
+
+	file, err := os.CreateTemp(dir, ".tmp-"+manager.cdiSpecFileName())
+	if err != nil {
+		return fmt.Errorf("failed to create CDI spec (%w)", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(bytes)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write CDI spec %s (%w)", file.Name(), err)
+	}
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write CDI spec %s (%w)", file.Name(), err)
+	}
+
+	specPath := filepath.Join(dir, manager.cdiSpecFileName())
+	err = os.Rename(file.Name(), specPath)
+	if err != nil {
+		return fmt.Errorf("failed to write CDI spec %s (%w)", specPath, err)
+	}
+	glog.Infof("Wrote CDI spec %s for %s", specPath, manager.cdiQualifiedName(cdiDeviceName))
+	return nil
+}
+
+// Initialises the CDI spec, if enabled, falling back to returning the device
+// nodes, mounts and environment from Allocate if it can't be written.
+func (manager *NicManager) initCDI() {
+	if !manager.config.CDI {
+		return
+	}
+	err := manager.writeCDISpec()
+	if err != nil {
+		glog.Warningf("Not using CDI, falling back to mounts (%v)", err)
+		return
+	}
+	manager.useCDI = true
+}
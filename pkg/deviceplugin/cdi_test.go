@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("Testing CDI", func() {
+	var config NicManagerConfig
+
+	BeforeEach(func() {
+		config = newFakeHost()
+		config.CDI = true
+		config.CDISpecDir = filepath.Join(GinkgoT().TempDir(), "cdi")
+	})
+
+	readSpec := func() cdiSpec {
+		name := strings.ReplaceAll(config.ResourceName, "/", "-") + ".yaml"
+		bytes, err := os.ReadFile(filepath.Join(config.CDISpecDir, name))
+		Expect(err).Should(Succeed())
+		spec := cdiSpec{}
+		Expect(yaml.UnmarshalStrict(bytes, &spec)).Should(Succeed())
+		return spec
+	}
+
+	It("should describe onload in the CDI spec", func() {
+		config.SetPreload = false
+		config.MountOnload = true
+		_, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		spec := readSpec()
+		Expect(spec.Version).Should(Equal("0.5.0"))
+		Expect(spec.Kind).Should(Equal("amd.com/onload"))
+		Expect(spec.Devices).Should(HaveLen(1))
+		Expect(spec.Devices[0].Name).Should(Equal("onload"))
+
+		edits := spec.Devices[0].ContainerEdits
+		Expect(edits.DeviceNodes).Should(ConsistOf(
+			cdiDeviceNode{Path: "/dev/onload", HostPath: "/dev/onload", Permissions: "mrw"},
+			cdiDeviceNode{Path: "/dev/onload_epoll", HostPath: "/dev/onload_epoll", Permissions: "mrw"},
+			cdiDeviceNode{Path: "/dev/sfc_char", HostPath: "/dev/sfc_char", Permissions: "mrw"},
+		))
+		Expect(edits.Mounts).Should(ContainElements(
+			cdiMount{
+				HostPath:      path.Join(config.HostPathPrefix, hostLib64path, "libonload.so"),
+				ContainerPath: path.Join(config.BaseMountPath, config.LibMountPath, "libonload.so"),
+				Options:       []string{"bind", "ro"},
+			},
+			cdiMount{
+				HostPath:      path.Join(config.HostPathPrefix, hostUsrBinPath, "onload"),
+				ContainerPath: path.Join(config.BaseMountPath, config.BinMountPath, "onload"),
+				Options:       []string{"bind", "ro"},
+			},
+		))
+		Expect(edits.Env).Should(BeEmpty())
+	})
+
+	It("should set LD_PRELOAD in the CDI spec", func() {
+		_, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(readSpec().Devices[0].ContainerEdits.Env).Should(Equal([]string{
+			"LD_PRELOAD=" + path.Join(config.BaseMountPath, config.LibMountPath, "libonload.so"),
+		}))
+	})
+
+	It("should allocate the CDI device", func() {
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		resp := allocate(manager, "")
+		Expect(resp.CDIDevices).Should(ConsistOf(HaveField("Name", "amd.com/onload=onload")))
+		Expect(resp.Devices).Should(BeEmpty())
+		Expect(resp.Mounts).Should(BeEmpty())
+		Expect(resp.Envs).Should(BeEmpty())
+
+		resp = allocate(manager, "enp2s0f0")
+		Expect(resp.CDIDevices).Should(HaveLen(1))
+		Expect(resp.Envs).Should(Equal(map[string]string{"EF_INTERFACE_WHITELIST": "enp2s0f0"}))
+	})
+
+	It("should name the CDI kind and spec after a configured resource name", func() {
+		config.ResourceName = "amd.com/enterprise-onload"
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(filepath.Join(config.CDISpecDir, "amd.com-enterprise-onload.yaml")).
+			Should(BeAnExistingFile())
+		Expect(readSpec().Kind).Should(Equal("amd.com/enterprise-onload"))
+		Expect(allocate(manager, "").CDIDevices).Should(ConsistOf(
+			HaveField("Name", "amd.com/enterprise-onload=onload")))
+	})
+
+	It("should fall back to mounts if the CDI spec can't be written", func() {
+		Expect(os.WriteFile(config.CDISpecDir, []byte{}, 0644)).Should(Succeed())
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		resp := allocate(manager, "")
+		Expect(resp.CDIDevices).Should(BeEmpty())
+		Expect(resp.Devices).Should(HaveLen(3))
+		Expect(resp.Envs).Should(HaveKey("LD_PRELOAD"))
+	})
+
+	It("should not write a CDI spec unless enabled", func() {
+		config.CDI = false
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(config.CDISpecDir).ShouldNot(BeAnExistingFile())
+		Expect(allocate(manager, "").CDIDevices).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// loadConfig returns base with the settings in its YAML or JSON config file
+// applied over it, along with the contents of the file. Settings missing from
+// the file keep their values.
+func loadConfig(base NicManagerConfig) (NicManagerConfig, []byte, error) {
+	contents, err := os.ReadFile(base.ConfigFile)
+	if err != nil {
+		return base, nil, fmt.Errorf("failed to read config file %s (%w)", base.ConfigFile, err)
+	}
+	config, err := parseConfig(contents, base)
+	return config, contents, err
+}
+
+// Returns base with the settings in contents applied over it.
+func parseConfig(contents []byte, base NicManagerConfig) (NicManagerConfig, error) {
+	config := base
+	err := yaml.Unmarshal(contents, &config)
+	if err != nil {
+		return base, fmt.Errorf("failed to parse config file %s (%w)", base.ConfigFile, err)
+	}
+	return config, nil
+}
+
+// Returns an error if the config is not usable.
+func validateConfig(config NicManagerConfig) error {
+	if config.SetPreload && config.MountOnload {
+		return errors.New("setting both usePreload and mountOnload is not supported")
+	}
+	if !strings.Contains(config.ResourceName, "/") {
+		return fmt.Errorf("invalid resourceName %q, which needs a domain prefix",
+			config.ResourceName)
+	}
+	if errs := validation.IsQualifiedName(config.ResourceName); len(errs) > 0 {
+		return fmt.Errorf("invalid resourceName %q (%s)", config.ResourceName,
+			strings.Join(errs, ", "))
+	}
+	if config.MaxPodsPerNode < 0 {
+		return fmt.Errorf("invalid maxPodsPerNode %d", config.MaxPodsPerNode)
+	}
+	for _, tool := range config.ToolMounts {
+		if tool == "" || strings.Contains(tool, "/") {
+			return fmt.Errorf("invalid tool %q in toolMounts", tool)
+		}
+	}
+	return nil
+}
+
+// Returns config with the settings that can't be changed while running
+// restored to those of current, warning about any that were changed.
+func keepFixedSettings(config NicManagerConfig, current NicManagerConfig) NicManagerConfig {
+	fixed := []struct {
+		name     string
+		value    any
+		previous any
+		restore  func()
+	}{
+		{"resourceName", config.ResourceName, current.ResourceName,
+			func() { config.ResourceName = current.ResourceName }},
+		{"hostOnloadPath", config.HostPathPrefix, current.HostPathPrefix,
+			func() { config.HostPathPrefix = current.HostPathPrefix }},
+		{"sysfsRoot", config.SysfsRoot, current.SysfsRoot,
+			func() { config.SysfsRoot = current.SysfsRoot }},
+		{"devRoot", config.DevRoot, current.DevRoot,
+			func() { config.DevRoot = current.DevRoot }},
+		{"perInterface", config.PerInterface, current.PerInterface,
+			func() { config.PerInterface = current.PerInterface }},
+		{"cdi", config.CDI, current.CDI,
+			func() { config.CDI = current.CDI }},
+		{"tcpdirect", config.TCPDirect, current.TCPDirect,
+			func() { config.TCPDirect = current.TCPDirect }},
+		{"efvi", config.EFVI, current.EFVI,
+			func() { config.EFVI = current.EFVI }},
+		{"profileDir", config.ProfileDir, current.ProfileDir,
+			func() { config.ProfileDir = current.ProfileDir }},
+		{"cdiSpecDir", config.CDISpecDir, current.CDISpecDir,
+			func() { config.CDISpecDir = current.CDISpecDir }},
+		{"metricsAddress", config.MetricsAddress, current.MetricsAddress,
+			func() { config.MetricsAddress = current.MetricsAddress }},
+		{"debugAddress", config.DebugAddress, current.DebugAddress,
+			func() { config.DebugAddress = current.DebugAddress }},
+		{"podResourcesSocket", config.PodResourcesSocket, current.PodResourcesSocket,
+			func() { config.PodResourcesSocket = current.PodResourcesSocket }},
+		{"nodeName", config.NodeName, current.NodeName,
+			func() { config.NodeName = current.NodeName }},
+	}
+	for _, setting := range fixed {
+		if setting.value != setting.previous {
+			glog.Warningf("Changing %s from %v to %v requires a restart",
+				setting.name, setting.previous, setting.value)
+			setting.restore()
+		}
+	}
+	return config
+}
+
+// reloadConfig applies a new config. Changes to the mounts and environment
+// take effect for subsequent Allocate calls, and changes to the number of
+// devices, or whether a NIC is needed, are sent to the kubelet. Other
+// settings can only be changed by restarting.
+func (manager *NicManager) reloadConfig(config NicManagerConfig) error {
+	err := validateConfig(config)
+	if err != nil {
+		return err
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	config = keepFixedSettings(config, manager.config)
+	if reflect.DeepEqual(config, manager.config) {
+		return nil
+	}
+	previous := manager.config
+	manager.config = config
+	glog.Infof("Reloaded config %+v", config)
+
+	manager.updateAllocateResponses()
+
+	if config.MaxPodsPerNode != previous.MaxPodsPerNode || config.NeedNic != previous.NeedNic {
+		// Rebuild every resource's devices.
+		manager.resources = map[string]resourceState{}
+		manager.setResources(manager.resourceStates(manager.nics))
+		close(manager.changed)
+		manager.changed = make(chan struct{})
+	}
+	return nil
+}
+
+// updateAllocateResponses rebuilds the mounts and environment given to
+// containers, and the CDI spec describing them if it is used, after the
+// config or profile has changed.
+func (manager *NicManager) updateAllocateResponses() {
+	manager.initMounts()
+	if manager.useCDI {
+		err := manager.writeCDISpec()
+		if err != nil {
+			glog.Warningf("Not using CDI, falling back to mounts (%v)", err)
+			manager.useCDI = false
+		}
+	}
+}
+
+// watchConfig reloads the config file whenever it changes, until ctx is
+// cancelled. The file's directory is watched, rather than the file, as the
+// kubelet updates a mounted ConfigMap by swapping a symlink to a new
+// directory.
+func (manager *NicManager) watchConfig(ctx context.Context) {
+	path := manager.baseConfig.ConfigFile
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Errorf("Failed to create config watcher, not reloading config (%v)", err)
+		return
+	}
+	defer watcher.Close()
+
+	err = watcher.Add(filepath.Dir(path))
+	if err != nil {
+		glog.Errorf("Failed to watch %s, not reloading config (%v)", path, err)
+		return
+	}
+
+	// Check for changes made before the watch started.
+	manager.checkConfig()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-watcher.Errors:
+			glog.Warningf("Config watcher failed (%v)", err)
+		case <-watcher.Events:
+			manager.checkConfig()
+		}
+	}
+}
+
+// checkConfig reloads the config file if it has changed since it was last
+// loaded.
+func (manager *NicManager) checkConfig() {
+	path := manager.baseConfig.ConfigFile
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		// Probably part way through an update.
+		glog.V(2).Infof("Failed to read config file %s (%v)", path, err)
+		return
+	}
+	if bytes.Equal(contents, manager.configContents) {
+		return
+	}
+	manager.configContents = contents
+
+	config, err := parseConfig(contents, manager.baseConfig)
+	if err == nil {
+		err = manager.reloadConfig(config)
+	}
+	if err != nil {
+		glog.Errorf("Failed to reload config file %s, keeping previous config (%v)", path, err)
+	}
+}
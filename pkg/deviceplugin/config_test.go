@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing the config file", func() {
+	var (
+		config     NicManagerConfig
+		configFile string
+	)
+
+	BeforeEach(func() {
+		config = newFakeHost()
+		configFile = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		config.ConfigFile = configFile
+	})
+
+	// How long to wait to be sure that a change to the config file is ignored.
+	const ignoredDuration = 500 * time.Millisecond
+
+	currentConfig := func(manager *NicManager) NicManagerConfig {
+		manager.mu.Lock()
+		defer manager.mu.Unlock()
+		return manager.config
+	}
+
+	writeConfig := func(contents string) {
+		Expect(os.WriteFile(configFile, []byte(contents), 0644)).Should(Succeed())
+	}
+
+	It("should apply the config file over the other settings", func() {
+		writeConfig("maxPodsPerNode: 3\nsetPreload: false\n")
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(manager.config.MaxPodsPerNode).Should(Equal(3))
+		Expect(manager.config.SetPreload).Should(BeFalse())
+		Expect(manager.config.BaseMountPath).Should(Equal(config.BaseMountPath))
+		Expect(manager.getDevices()).Should(HaveLen(3))
+	})
+
+	It("should accept a JSON config file", func() {
+		writeConfig(`{"libMountPath": "/usr/lib"}`)
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+		Expect(manager.config.LibMountPath).Should(Equal("/usr/lib"))
+	})
+
+	It("should fail with an invalid config file", func() {
+		writeConfig("setPreload: true\nmountOnload: true\n")
+		_, err := NewNicManager(config)
+		Expect(err).Should(HaveOccurred())
+
+		writeConfig("maxPodsPerNode: [")
+		_, err = NewNicManager(config)
+		Expect(err).Should(HaveOccurred())
+
+		writeConfig("toolMounts:\n- ../sbin/onload_cp_server\n")
+		_, err = NewNicManager(config)
+		Expect(err).Should(HaveOccurred())
+
+		writeConfig("resourceName: onload\n")
+		_, err = NewNicManager(config)
+		Expect(err).Should(HaveOccurred())
+
+		writeConfig("resourceName: amd.com/open onload\n")
+		_, err = NewNicManager(config)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should fail without the config file", func() {
+		_, err := NewNicManager(config)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	Context("reloading", func() {
+		var (
+			manager *NicManager
+			rpc     *RPCServer
+			ctx     context.Context
+			cancel  context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			writeConfig("maxPodsPerNode: 2\n")
+			var err error
+			manager, err = NewNicManager(config)
+			Expect(err).Should(Succeed())
+			// The RPC server is created before the config can change, as
+			// runServers does so holding the lock.
+			rpc = NewRPCServer(manager, "")
+
+			ctx, cancel = context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			go manager.watchConfig(ctx)
+		})
+
+		It("should apply changes to the environment and mounts to new allocations", func() {
+			Expect(allocateFrom(rpc).Envs).Should(HaveKey("LD_PRELOAD"))
+
+			writeConfig("maxPodsPerNode: 2\nsetPreload: false\nmountOnload: true\nbaseMountPath: /onload\n")
+			Eventually(func() map[string]string { return allocateFrom(rpc).Envs }).
+				ShouldNot(HaveKey("LD_PRELOAD"))
+
+			Expect(allocateFrom(rpc).Mounts).Should(ContainElement(
+				HaveField("ContainerPath", path.Join("/onload", config.BinMountPath, "onload")),
+			))
+		})
+
+		It("should send changes to the number of devices to the kubelet", func() {
+			_, changed := manager.watchDevices("")
+			writeConfig("maxPodsPerNode: 5\n")
+			Eventually(changed).Should(BeClosed())
+			Expect(manager.getDevices()).Should(HaveLen(5))
+		})
+
+		It("should keep the previous config if the new one is invalid", func() {
+			writeConfig("maxPodsPerNode: 2\nsetPreload: true\nmountOnload: true\n")
+			Consistently(func() map[string]string { return allocateFrom(rpc).Envs }).
+				WithTimeout(ignoredDuration).Should(HaveKey("LD_PRELOAD"))
+			Expect(manager.getDevices()).Should(HaveLen(2))
+		})
+
+		It("should not change settings which need a restart", func() {
+			_, changed := manager.watchDevices("")
+			writeConfig("maxPodsPerNode: 2\nperInterface: true\n")
+			Consistently(changed).WithTimeout(ignoredDuration).ShouldNot(BeClosed())
+			Expect(currentConfig(manager).PerInterface).Should(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// The path that the device plugin's state is served on, for debugging.
+const debugStatePath = "/state"
+
+// debugState describes what the device plugin is doing.
+type debugState struct {
+	Config     NicManagerConfig `json:"config"`
+	Interfaces []Nic            `json:"interfaces"`
+	// CDI is true if containers are given the CDI devices described by the
+	// CDI spec, rather than the mounts and environment directly.
+	CDI       bool            `json:"cdi"`
+	Resources []debugResource `json:"resources"`
+}
+
+// debugResource describes one of the resources advertised.
+type debugResource struct {
+	Name string `json:"name"`
+	// Interface is the interface the resource is restricted to, if it is.
+	Interface string `json:"interface,omitempty"`
+	Health    string `json:"health"`
+	// Reason is why the resource is unhealthy, if it is.
+	Reason    string              `json:"reason,omitempty"`
+	NUMANodes []int               `json:"numaNodes"`
+	Devices   []*pluginapi.Device `json:"devices"`
+	// Allocate is what a container given the resource is given.
+	Allocate *pluginapi.ContainerAllocateResponse `json:"allocate"`
+	Socket   string                               `json:"socket,omitempty"`
+	// RegisteredAt is when the resource was registered with the kubelet, if
+	// it currently is.
+	RegisteredAt *time.Time `json:"registeredAt,omitempty"`
+	// RegistrationError is why registering the resource last failed, if it
+	// did.
+	RegistrationError string `json:"registrationError,omitempty"`
+	// LastListAndWatchSend is when the devices were last sent to the
+	// kubelet, if they have been.
+	LastListAndWatchSend *time.Time `json:"lastListAndWatchSend,omitempty"`
+}
+
+// getDebugState returns what the device plugin is doing, with the resources
+// in a stable order.
+func (manager *NicManager) getDebugState() debugState {
+	manager.mu.Lock()
+	state := debugState{
+		Config:     manager.config,
+		Interfaces: slices.Clone(manager.nics),
+		CDI:        manager.useCDI,
+		Resources:  []debugResource{},
+	}
+	ifaces := []string{}
+	for iface, res := range manager.resources {
+		ifaces = append(ifaces, iface)
+		debugRes := debugResource{
+			Name:      manager.resourceNameFor(iface),
+			Health:    res.health,
+			NUMANodes: res.numaNodes,
+			Devices:   manager.devices[iface],
+		}
+		if _, ok := apiResourceFor(iface); !ok {
+			debugRes.Interface = iface
+		}
+		if err := manager.reasons[iface]; err != nil {
+			debugRes.Reason = err.Error()
+		}
+		if sent, ok := manager.lastSends[iface]; ok {
+			debugRes.LastListAndWatchSend = &sent
+		}
+		if rpc, ok := manager.rpcServers[iface]; ok {
+			debugRes.Socket = rpc.listenSockPath
+			registeredAt, err := rpc.registration()
+			if !registeredAt.IsZero() {
+				debugRes.RegisteredAt = &registeredAt
+			}
+			if err != nil {
+				debugRes.RegistrationError = err.Error()
+			}
+		}
+		state.Resources = append(state.Resources, debugRes)
+	}
+	manager.mu.Unlock()
+
+	// containerAllocateResponse takes the lock itself.
+	for i := range state.Resources {
+		state.Resources[i].Allocate = manager.containerAllocateResponse(ifaces[i])
+	}
+	slices.SortFunc(state.Resources, func(a, b debugResource) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return state
+}
+
+// debugHandler returns a handler serving what the device plugin is doing as
+// JSON.
+func (manager *NicManager) debugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(manager.getDebugState())
+		if err != nil {
+			glog.Warningf("Failed to serve device plugin state (%v)", err)
+		}
+	})
+}
+
+// debugMux returns a handler serving what the device plugin is doing and the
+// allocations.
+func (manager *NicManager) debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(debugStatePath, manager.debugHandler())
+	mux.Handle(allocationsPath, manager.allocationsHandler())
+	return mux
+}
+
+// serveDebug serves what the device plugin is doing, and the allocations, over
+// HTTP on the configured address until ctx is cancelled. Failing to serve them
+// is logged, rather than stopping the device plugin.
+func (manager *NicManager) serveDebug(ctx context.Context) {
+	server := &http.Server{
+		Addr:              manager.config.DebugAddress,
+		Handler:           manager.debugMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	glog.Infof("Serving device plugin state on %s%s", server.Addr, debugStatePath)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		glog.Errorf("Failed to serve device plugin state on %s (%v)", server.Addr, err)
+	}
+}
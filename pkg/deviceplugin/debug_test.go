@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("Testing the debug state", func() {
+	var (
+		config  NicManagerConfig
+		manager *NicManager
+	)
+
+	BeforeEach(func() {
+		config = newFakeHost()
+		config.MaxPodsPerNode = 2
+		config.PodResourcesSocket = ""
+		config.EFVI = true
+		var err error
+		manager, err = NewNicManager(config)
+		Expect(err).Should(Succeed())
+	})
+
+	getState := func() debugState {
+		recorder := httptest.NewRecorder()
+		manager.debugHandler().ServeHTTP(recorder,
+			httptest.NewRequest(http.MethodGet, debugStatePath, nil))
+		Expect(recorder.Code).Should(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).Should(Equal("application/json"))
+
+		state := debugState{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &state)).Should(Succeed())
+		return state
+	}
+
+	It("should describe the config, interfaces and resources", func() {
+		state := getState()
+		Expect(state.Config.MaxPodsPerNode).Should(Equal(2))
+		Expect(state.Interfaces).Should(ConsistOf(
+			HaveField("Interface", "enp2s0f0"),
+		))
+		Expect(state.Resources).Should(HaveLen(2))
+
+		efvi := state.Resources[0]
+		Expect(efvi.Name).Should(Equal("amd.com/efvi"))
+		Expect(efvi.Interface).Should(BeEmpty())
+		Expect(efvi.Health).Should(Equal(pluginapi.Unhealthy))
+		Expect(efvi.Reason).Should(ContainSubstring("libciul1.so"))
+
+		onload := state.Resources[1]
+		Expect(onload.Name).Should(Equal("amd.com/onload"))
+		Expect(onload.Health).Should(Equal(pluginapi.Healthy))
+		Expect(onload.Reason).Should(BeEmpty())
+		Expect(onload.Devices).Should(HaveLen(2))
+		Expect(onload.Allocate.Envs).Should(HaveKey("LD_PRELOAD"))
+		Expect(onload.Allocate.Mounts).Should(ContainElement(
+			HaveField("ContainerPath", "/opt/onload/usr/lib64/libonload.so")))
+		Expect(onload.RegisteredAt).Should(BeNil())
+		Expect(onload.LastListAndWatchSend).Should(BeNil())
+	})
+
+	It("should say why devices are unhealthy", func() {
+		Expect(os.Remove(filepath.Join(config.DevRoot, "/dev/onload"))).Should(Succeed())
+		Expect(manager.updateHealth()).Should(BeTrue())
+
+		state := getState()
+		Expect(state.Resources[1].Health).Should(Equal(pluginapi.Unhealthy))
+		Expect(state.Resources[1].Reason).Should(ContainSubstring("/dev/onload"))
+	})
+
+	It("should describe registration and the last ListAndWatch send", func() {
+		manager.pluginDir = GinkgoT().TempDir()
+		requests := make(chan *pluginapi.RegisterRequest, 10)
+		kubelet := startFakeKubelet(path.Join(manager.pluginDir, "kubelet.sock"), requests)
+		defer kubelet.server.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- manager.Run(ctx)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(runErr).Should(Receive(BeNil()))
+		})
+
+		Eventually(requests, "10s").Should(Receive())
+		Eventually(requests, "10s").Should(Receive())
+		Eventually(func() *time.Time { return getState().Resources[1].RegisteredAt }).
+			ShouldNot(BeNil())
+		Expect(getState().Resources[1].Socket).
+			Should(Equal(path.Join(manager.pluginDir, "sfc-deviceplugin.sock")))
+
+		conn, err := grpcDial(ctx, path.Join(manager.pluginDir, "sfc-deviceplugin.sock"), 5*time.Second)
+		Expect(err).Should(Succeed())
+		defer conn.Close()
+		stream, err := pluginapi.NewDevicePluginClient(conn).ListAndWatch(ctx, &pluginapi.Empty{})
+		Expect(err).Should(Succeed())
+		_, err = stream.Recv()
+		Expect(err).Should(Succeed())
+		Eventually(func() *time.Time { return getState().Resources[1].LastListAndWatchSend }).
+			ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// How often to check the health of the devices. The link state of the
+// interfaces and the loaded modules can't be watched, as sysfs doesn't
+// generate inotify events, so they are polled.
+const healthCheckInterval = 5 * time.Second
+
+// The file, under the host's onload path, in which the Onload Operator's init
+// container records the version of the onload userland it installed.
+const userlandVersionFile = "onload-version"
+
+// checkVersions returns an error if the version of the onload userland
+// installed on the host differs from that of the loaded onload module, as
+// pods would fail to use onload. Either version being unknown, such as when
+// onload was installed some other way, isn't an error.
+func (manager *NicManager) checkVersions() error {
+	userland, err := os.ReadFile(path.Join(manager.config.HostPathPrefix, userlandVersionFile))
+	if err != nil {
+		return nil
+	}
+	module, err := os.ReadFile(filepath.Join(manager.config.SysfsRoot, "module", "onload", "version"))
+	if err != nil {
+		return nil
+	}
+
+	userlandVersion := strings.TrimSpace(string(userland))
+	moduleVersion := strings.TrimSpace(string(module))
+	if userlandVersion == "" || moduleVersion == "" || userlandVersion == moduleVersion {
+		return nil
+	}
+	return fmt.Errorf("onload userland version %s doesn't match loaded module version %s",
+		userlandVersion, moduleVersion)
+}
+
+// checkNics returns why pods can't currently use the NICs on this node, if
+// one is needed, or nil if they can.
+func (manager *NicManager) checkNics(nics []Nic) error {
+	if !manager.config.NeedNic {
+		return nil
+	}
+	if len(nics) == 0 {
+		return errors.New("no sfc interfaces found")
+	}
+	if !slices.ContainsFunc(nics, func(nic Nic) bool { return nic.LinkUp }) {
+		return errors.New("no sfc interface has link up")
+	}
+	return nil
+}
+
+// checkHealth returns why pods can't currently use onload on this node, or
+// nil if they can.
+func (manager *NicManager) checkHealth(nics []Nic) error {
+	err := manager.checkNics(nics)
+	if err != nil {
+		return err
+	}
+	return manager.checkInstallation()
+}
+
+// checkInterfaceHealth returns why pods can't currently use onload to
+// accelerate the given interface, or nil if they can.
+func (manager *NicManager) checkInterfaceHealth(iface string, nics []Nic) error {
+	i := slices.IndexFunc(nics, func(nic Nic) bool { return nic.Interface == iface })
+	if i < 0 {
+		return fmt.Errorf("interface %s not found", iface)
+	}
+	if !nics[i].LinkUp {
+		return fmt.Errorf("interface %s has link down", iface)
+	}
+	return manager.checkInstallation()
+}
+
+// checkInstallation returns why onload isn't currently usable on this node,
+// regardless of the NICs, or nil if it is.
+func (manager *NicManager) checkInstallation() error {
+	moduleDir := filepath.Join(manager.config.SysfsRoot, "module", "onload")
+	if _, err := os.Stat(moduleDir); err != nil {
+		return fmt.Errorf("onload module is not loaded (%w)", err)
+	}
+
+	for _, device := range deviceMounts {
+		if _, err := os.Stat(filepath.Join(manager.config.DevRoot, device)); err != nil {
+			return fmt.Errorf("device %s is missing (%w)", device, err)
+		}
+	}
+
+	for _, library := range libraryMounts {
+		libraryPath := path.Join(manager.config.HostPathPrefix, hostLib64path, library)
+		if _, err := os.Stat(libraryPath); err != nil {
+			return fmt.Errorf("library %s is missing (%w)", library, err)
+		}
+	}
+
+	return manager.checkVersions()
+}
+
+// The module parameter telling onload how to start its control plane server,
+// which the Onload Worker sets so that it runs in the Onload Worker's
+// container.
+const cplaneServerParams = "cplane_server_params"
+
+// checkControlPlane returns an error if onload hasn't been told how to start
+// its control plane server, as onload would then fail to start one when a
+// container first uses it. Where the module's parameters can't be seen, the
+// control plane isn't checked.
+func (manager *NicManager) checkControlPlane() error {
+	parametersDir := filepath.Join(manager.config.SysfsRoot, "module", "onload", "parameters")
+	if _, err := os.Stat(parametersDir); err != nil {
+		return nil
+	}
+	params, err := os.ReadFile(filepath.Join(parametersDir, cplaneServerParams))
+	if err != nil {
+		return fmt.Errorf("failed to read onload control plane parameters (%w)", err)
+	}
+	if strings.TrimSpace(string(params)) == "" {
+		return errors.New("onload control plane parameters are not configured")
+	}
+	return nil
+}
+
+// checkReady returns why a container can't use the resource restricted to
+// the given interface, the shared resource if it is "", or the API resource
+// with that name, or nil if it can. Unlike the health checks, this checks
+// exactly what the container is given, just before it starts.
+func (manager *NicManager) checkReady(iface string) error {
+	manager.mu.Lock()
+	devices, mounts, controlPlane := manager.deviceFiles, manager.mounts, true
+	res, isAPI := apiResourceFor(iface)
+	resp := manager.apiResponses[res.name]
+	if isAPI && resp != nil {
+		devices, mounts, controlPlane = resp.Devices, resp.Mounts, res.controlPlane
+	}
+	manager.mu.Unlock()
+	if isAPI && resp == nil {
+		return fmt.Errorf("%s is not enabled", res.name)
+	}
+
+	if controlPlane {
+		moduleDir := filepath.Join(manager.config.SysfsRoot, "module", "onload")
+		if _, err := os.Stat(moduleDir); err != nil {
+			return fmt.Errorf("onload module is not loaded (%w)", err)
+		}
+		err := manager.checkControlPlane()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, device := range devices {
+		if _, err := os.Stat(filepath.Join(manager.config.DevRoot, device.HostPath)); err != nil {
+			return fmt.Errorf("device %s is missing (%w)", device.HostPath, err)
+		}
+	}
+
+	for _, mount := range mounts {
+		if _, err := os.Stat(mount.HostPath); err != nil {
+			return fmt.Errorf("mounted file %s is missing (%w)", mount.HostPath, err)
+		}
+	}
+	return nil
+}
+
+// Returns the device health matching the result of checkHealth.
+func healthOf(err error) string {
+	if err != nil {
+		return pluginapi.Unhealthy
+	}
+	return pluginapi.Healthy
+}
+
+// updateHealth re-checks the NICs and the onload installation on the node,
+// updating the devices and notifying their watchers if the health, topology
+// or set of resources has changed. Returns true if any has.
+func (manager *NicManager) updateHealth() bool {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	nics, err := manager.queryNics()
+	if err != nil {
+		// Keep the last known NICs rather than guessing.
+		glog.Errorf("Failed to query nics (%v)", err)
+		nics = manager.nics
+	} else if !reflect.DeepEqual(nics, manager.nics) {
+		glog.Infof("SFC interfaces on host have changed (%+v -> %+v)",
+			manager.nics, nics)
+		manager.nics = nics
+	}
+
+	if !manager.setResources(manager.resourceStates(nics)) {
+		return false
+	}
+	close(manager.changed)
+	manager.changed = make(chan struct{})
+	return true
+}
+
+// Adds the directories whose contents affect the health of the devices to
+// watcher. Directories that don't exist yet are skipped; they are retried
+// whenever something is created in their parent.
+func (manager *NicManager) watchHealthPaths(watcher *fsnotify.Watcher) {
+	dirs := []string{
+		filepath.Join(manager.config.DevRoot, "dev"),
+		manager.config.HostPathPrefix,
+		filepath.Join(manager.config.HostPathPrefix, "usr"),
+		filepath.Join(manager.config.HostPathPrefix, hostLib64path),
+	}
+	for _, dir := range dirs {
+		err := watcher.Add(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			glog.Warningf("Failed to watch %s (%v)", dir, err)
+		}
+	}
+}
+
+// monitorHealth keeps the health of the devices up to date until ctx is
+// cancelled. The device nodes and libraries are watched so that changes to
+// them are noticed immediately; everything is also checked periodically.
+func (manager *NicManager) monitorHealth(ctx context.Context) {
+	var events chan fsnotify.Event
+	var errs chan error
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Errorf("Failed to create health watcher, polling instead (%v)", err)
+	} else {
+		defer watcher.Close()
+		manager.watchHealthPaths(watcher)
+		events = watcher.Events
+		errs = watcher.Errors
+	}
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		manager.updateHealth()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case event := <-events:
+			if event.Has(fsnotify.Create) {
+				manager.watchHealthPaths(watcher)
+			}
+		case err := <-errs:
+			glog.Warningf("Health watcher failed (%v)", err)
+		}
+	}
+}
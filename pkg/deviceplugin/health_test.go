@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("Testing device health", func() {
+	var (
+		config  NicManagerConfig
+		manager *NicManager
+	)
+
+	BeforeEach(func() {
+		config = newFakeHost()
+		config.MaxPodsPerNode = 2
+	})
+
+	newManager := func() *NicManager {
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+		return manager
+	}
+
+	It("should be healthy when everything is present", func() {
+		manager = newManager()
+		Expect(manager.checkHealth(manager.nics)).Should(Succeed())
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+	})
+
+	DescribeTable("should be unhealthy when something is missing",
+		func(missing func(config NicManagerConfig) string) {
+			manager = newManager()
+			Expect(os.RemoveAll(missing(config))).Should(Succeed())
+			Expect(manager.updateHealth()).Should(BeTrue())
+			Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+		},
+		Entry("onload module", func(config NicManagerConfig) string {
+			return filepath.Join(config.SysfsRoot, "module", "onload")
+		}),
+		Entry("/dev/onload", func(config NicManagerConfig) string {
+			return filepath.Join(config.DevRoot, "/dev/onload")
+		}),
+		Entry("/dev/onload_epoll", func(config NicManagerConfig) string {
+			return filepath.Join(config.DevRoot, "/dev/onload_epoll")
+		}),
+		Entry("/dev/sfc_char", func(config NicManagerConfig) string {
+			return filepath.Join(config.DevRoot, "/dev/sfc_char")
+		}),
+		Entry("libonload.so", func(config NicManagerConfig) string {
+			return path.Join(config.HostPathPrefix, hostLib64path, "libonload.so")
+		}),
+		Entry("libonload_ext.so", func(config NicManagerConfig) string {
+			return path.Join(config.HostPathPrefix, hostLib64path, "libonload_ext.so")
+		}),
+		Entry("sfc interface", func(config NicManagerConfig) string {
+			return filepath.Join(config.SysfsRoot, "class", "net", "enp2s0f0")
+		}),
+	)
+
+	It("should be unhealthy when no link is up", func() {
+		operState := filepath.Join(config.SysfsRoot, "class", "net", "enp2s0f0", "operstate")
+		Expect(os.WriteFile(operState, []byte("down\n"), 0644)).Should(Succeed())
+		manager = newManager()
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+	})
+
+	It("should be unhealthy when the userland and module versions differ", func() {
+		moduleVersion := filepath.Join(config.SysfsRoot, "module", "onload", "version")
+		userlandVersion := path.Join(config.HostPathPrefix, userlandVersionFile)
+		Expect(os.WriteFile(moduleVersion, []byte("8.1.2.26\n"), 0644)).Should(Succeed())
+		manager = newManager()
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+
+		Expect(os.WriteFile(userlandVersion, []byte("8.1.3.40\n"), 0644)).Should(Succeed())
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.checkInstallation()).Should(MatchError(ContainSubstring(
+			"onload userland version 8.1.3.40 doesn't match loaded module version 8.1.2.26")))
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+
+		Expect(os.WriteFile(moduleVersion, []byte("8.1.3.40\n"), 0644)).Should(Succeed())
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+	})
+
+	It("should notice changes to the devices without waiting to poll", func() {
+		manager = newManager()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go manager.monitorHealth(ctx)
+
+		_, changed := manager.watchDevices("")
+		device := filepath.Join(config.DevRoot, "/dev/onload")
+		Expect(os.Remove(device)).Should(Succeed())
+		Eventually(changed).WithTimeout(healthCheckInterval / 2).Should(BeClosed())
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+
+		_, changed = manager.watchDevices("")
+		Expect(os.WriteFile(device, []byte{}, 0644)).Should(Succeed())
+		Eventually(changed).WithTimeout(healthCheckInterval / 2).Should(BeClosed())
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+	})
+
+	It("should send changes to every ListAndWatch stream", func() {
+		manager = newManager()
+
+		tmpDir, err := os.MkdirTemp("", "device-plugins")
+		Expect(err).Should(Succeed())
+		DeferCleanup(os.RemoveAll, tmpDir)
+
+		rpc := &RPCServer{
+			manager:        manager,
+			listenSockPath: path.Join(tmpDir, "sfc-deviceplugin.sock"),
+			serveErr:       make(chan error, 1),
+		}
+		Expect(rpc.start()).Should(Succeed())
+		DeferCleanup(rpc.stop)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		conn, err := grpcDial(ctx, rpc.listenSockPath, 5*healthCheckInterval)
+		Expect(err).Should(Succeed())
+		defer conn.Close()
+		client := pluginapi.NewDevicePluginClient(conn)
+
+		streams := []pluginapi.DevicePlugin_ListAndWatchClient{}
+		for i := 0; i < 2; i++ {
+			stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+			Expect(err).Should(Succeed())
+			resp, err := stream.Recv()
+			Expect(err).Should(Succeed())
+			Expect(resp.Devices).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+			streams = append(streams, stream)
+		}
+
+		Expect(os.Remove(filepath.Join(config.DevRoot, "/dev/sfc_char"))).Should(Succeed())
+		Expect(manager.updateHealth()).Should(BeTrue())
+
+		for _, stream := range streams {
+			resp, err := stream.Recv()
+			Expect(err).Should(Succeed())
+			Expect(resp.Devices).Should(HaveLen(2))
+			Expect(resp.Devices).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+		}
+	})
+})
+
+var _ = Describe("Testing readiness before containers start", func() {
+	var (
+		config  NicManagerConfig
+		manager *NicManager
+	)
+
+	BeforeEach(func() {
+		config = newFakeHost()
+		config.MaxPodsPerNode = 2
+		var err error
+		manager, err = NewNicManager(config)
+		Expect(err).Should(Succeed())
+	})
+
+	preStart := func(iface string) error {
+		_, err := NewRPCServer(manager, iface).PreStartContainer(context.Background(),
+			&pluginapi.PreStartContainerRequest{DevicesIDs: []string{"sfc-0"}})
+		return err
+	}
+
+	It("should require PreStartContainer", func() {
+		opts, err := NewRPCServer(manager, "").GetDevicePluginOptions(context.Background(),
+			&pluginapi.Empty{})
+		Expect(err).Should(Succeed())
+		Expect(opts.PreStartRequired).Should(BeTrue())
+	})
+
+	It("should let containers start when onload is ready", func() {
+		Expect(preStart("")).Should(Succeed())
+	})
+
+	DescribeTable("should stop containers starting when onload isn't ready",
+		func(notReady func(config NicManagerConfig), reason string) {
+			notReady(config)
+			Expect(preStart("")).Should(MatchError(ContainSubstring(reason)))
+		},
+		Entry("onload module", func(config NicManagerConfig) {
+			Expect(os.RemoveAll(filepath.Join(config.SysfsRoot, "module", "onload"))).Should(Succeed())
+		}, "onload module is not loaded"),
+		Entry("control plane", func(config NicManagerConfig) {
+			Expect(os.WriteFile(filepath.Join(config.SysfsRoot, "module", "onload", "parameters",
+				cplaneServerParams), []byte("\n"), 0644)).Should(Succeed())
+		}, "onload control plane parameters are not configured"),
+		Entry("/dev/sfc_char", func(config NicManagerConfig) {
+			Expect(os.Remove(filepath.Join(config.DevRoot, "/dev/sfc_char"))).Should(Succeed())
+		}, "device /dev/sfc_char is missing"),
+		Entry("libonload.so", func(config NicManagerConfig) {
+			Expect(os.Remove(path.Join(config.HostPathPrefix, hostLib64path, "libonload.so"))).
+				Should(Succeed())
+		}, "libonload.so is missing"),
+	)
+
+	It("should only check what API resources use", func() {
+		config.EFVI = true
+		Expect(os.WriteFile(path.Join(config.HostPathPrefix, hostLib64path, "libciul1.so.1"),
+			[]byte{}, 0644)).Should(Succeed())
+		var err error
+		manager, err = NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(os.RemoveAll(filepath.Join(config.SysfsRoot, "module", "onload"))).Should(Succeed())
+		Expect(preStart("amd.com/efvi")).Should(Succeed())
+		Expect(preStart("")).ShouldNot(Succeed())
+		Expect(preStart("amd.com/tcpdirect")).Should(MatchError(ContainSubstring("not enabled")))
+	})
+})
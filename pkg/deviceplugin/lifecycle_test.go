@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// fakeDiscoverer returns whichever NICs the test has set.
+type fakeDiscoverer struct {
+	mu   sync.Mutex
+	nics []Nic
+}
+
+func (discoverer *fakeDiscoverer) DiscoverNics() ([]Nic, error) {
+	discoverer.mu.Lock()
+	defer discoverer.mu.Unlock()
+	return slices.Clone(discoverer.nics), nil
+}
+
+func (discoverer *fakeDiscoverer) setNics(nics ...Nic) {
+	discoverer.mu.Lock()
+	defer discoverer.mu.Unlock()
+	discoverer.nics = nics
+}
+
+var _ = Describe("Testing the device plugin lifecycle", func() {
+	var (
+		config      NicManagerConfig
+		discoverer  *fakeDiscoverer
+		manager     *NicManager
+		kubeletSock string
+		requests    chan *pluginapi.RegisterRequest
+		kubelet     *fakeKubelet
+		ctx         context.Context
+	)
+
+	BeforeEach(func() {
+		discoverer = &fakeDiscoverer{}
+		discoverer.setNics(fakeNic("sfc0", -1, true), fakeNic("sfc1", -1, true))
+
+		config = newFakeHost()
+		config.MaxPodsPerNode = 2
+		config.PodResourcesSocket = ""
+		config.Discoverer = discoverer
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		manager, err = NewNicManager(config)
+		Expect(err).Should(Succeed())
+		manager.pluginDir = GinkgoT().TempDir()
+
+		kubeletSock = path.Join(manager.pluginDir, "kubelet.sock")
+		requests = make(chan *pluginapi.RegisterRequest, 10)
+		kubelet = startFakeKubelet(kubeletSock, requests)
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- manager.Run(ctx)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(runErr).Should(Receive(BeNil()))
+			kubelet.server.Stop()
+		})
+	})
+
+	// Returns the next registration with the fake kubelet.
+	nextRegistration := func() *pluginapi.RegisterRequest {
+		var req *pluginapi.RegisterRequest
+		Eventually(requests, "10s").Should(Receive(&req))
+		return req
+	}
+
+	// Connects to the device plugin registered by req, as the kubelet would.
+	connect := func(req *pluginapi.RegisterRequest) pluginapi.DevicePluginClient {
+		conn, err := grpcDial(ctx, path.Join(manager.pluginDir, req.Endpoint), 5*time.Second)
+		Expect(err).Should(Succeed())
+		DeferCleanup(conn.Close)
+		return pluginapi.NewDevicePluginClient(conn)
+	}
+
+	// Starts ListAndWatch, returning a function which receives the devices
+	// next sent.
+	listAndWatch := func(client pluginapi.DevicePluginClient) func() []*pluginapi.Device {
+		stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+		Expect(err).Should(Succeed())
+		return func() []*pluginapi.Device {
+			resp, err := stream.Recv()
+			Expect(err).Should(Succeed())
+			return resp.Devices
+		}
+	}
+
+	It("should find the NICs with the discoverer", func() {
+		Expect(manager.GetInterfaces()).Should(Equal([]string{"sfc0", "sfc1"}))
+	})
+
+	It("should register, advertise and allocate the devices", func() {
+		req := nextRegistration()
+		Expect(req.ResourceName).Should(Equal(defaultResourceName))
+		Expect(req.Options.PreStartRequired).Should(BeTrue())
+		client := connect(req)
+
+		recv := listAndWatch(client)
+		Expect(recv()).Should(SatisfyAll(
+			HaveLen(2),
+			HaveEach(HaveField("Health", pluginapi.Healthy)),
+		))
+
+		_, err := client.PreStartContainer(ctx,
+			&pluginapi.PreStartContainerRequest{DevicesIDs: []string{"sfc-0"}})
+		Expect(err).Should(Succeed())
+
+		resp, err := client.Allocate(ctx, &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+				{DevicesIDs: []string{"sfc-0"}},
+				{DevicesIDs: []string{"sfc-1"}},
+			},
+		})
+		Expect(err).Should(Succeed())
+		Expect(resp.ContainerResponses).Should(HaveLen(2))
+		Expect(resp.ContainerResponses[0].Envs).Should(HaveKey("LD_PRELOAD"))
+		Expect(resp.ContainerResponses[0].Devices).Should(
+			ContainElement(HaveField("ContainerPath", "/dev/onload")))
+	})
+
+	It("should tell the kubelet when the NICs change", func() {
+		recv := listAndWatch(connect(nextRegistration()))
+		Expect(recv()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+
+		discoverer.setNics()
+		manager.updateHealth()
+		Expect(recv()).Should(HaveEach(HaveField("Health", pluginapi.Unhealthy)))
+
+		discoverer.setNics(fakeNic("sfc1", -1, true))
+		manager.updateHealth()
+		Expect(recv()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+		Expect(manager.GetInterfaces()).Should(Equal([]string{"sfc1"}))
+	})
+
+	It("should re-register and serve again after the kubelet restarts", func() {
+		nextRegistration()
+
+		kubelet.server.Stop()
+		kubelet = startFakeKubelet(kubeletSock, requests)
+
+		req := nextRegistration()
+		Expect(req.ResourceName).Should(Equal(defaultResourceName))
+		recv := listAndWatch(connect(req))
+		Expect(recv()).Should(HaveEach(HaveField("Health", pluginapi.Healthy)))
+	})
+
+	When("advertising a resource for each interface", func() {
+		BeforeEach(func() {
+			config.PerInterface = true
+		})
+
+		It("should register a resource for each interface as it appears", func() {
+			names := []string{nextRegistration().ResourceName, nextRegistration().ResourceName}
+			Expect(names).Should(ConsistOf(defaultResourceName+"-sfc0", defaultResourceName+"-sfc1"))
+
+			discoverer.setNics(fakeNic("sfc0", -1, true), fakeNic("sfc1", -1, true),
+				fakeNic("sfc2", -1, true))
+			manager.updateHealth()
+			req := nextRegistration()
+			Expect(req.ResourceName).Should(Equal(defaultResourceName + "-sfc2"))
+
+			resp, err := connect(req).Allocate(ctx, &pluginapi.AllocateRequest{
+				ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+					{DevicesIDs: []string{"sfc-0"}},
+				},
+			})
+			Expect(err).Should(Succeed())
+			Expect(resp.ContainerResponses[0].Envs).Should(
+				HaveKeyWithValue(interfaceWhitelistEnv, "sfc2"))
+		})
+	})
+})
@@ -3,63 +3,188 @@
 package deviceplugin
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"reflect"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
-// The name of the resource we provide; this is the key that the user
+// The default name of the resource we provide; this is the key that the user
 // puts in a pod spec's "resources" section to request onload.
-const resourceName = "amd.com/onload"
+const defaultResourceName = "amd.com/onload"
 
-// NicManagerConfig describes the configuration of the NicManager.
+// NicManagerConfig describes the configuration of the NicManager. The json
+// names are those used in the config file.
 type NicManagerConfig struct {
-	MaxPodsPerNode int
-	SetPreload     bool
-	MountOnload    bool
-	HostPathPrefix string
-	BaseMountPath  string
-	BinMountPath   string
-	LibMountPath   string
-	NeedNic        bool
+	// ResourceName is the name of the shared resource, which also prefixes
+	// the per-interface resources and names the CDI kind. Setting different
+	// names lets more than one flavour of onload be advertised.
+	ResourceName   string `json:"resourceName"`
+	MaxPodsPerNode int    `json:"maxPodsPerNode"`
+	SetPreload     bool   `json:"setPreload"`
+	MountOnload    bool   `json:"mountOnload"`
+	HostPathPrefix string `json:"hostOnloadPath"`
+	BaseMountPath  string `json:"baseMountPath"`
+	BinMountPath   string `json:"binMountPath"`
+	LibMountPath   string `json:"libMountPath"`
+	// ExtraLibMountPaths are further directories, under BaseMountPath, in
+	// which to mount the onload libraries.
+	ExtraLibMountPaths []string `json:"extraLibMountPaths"`
+	// Lib32MountPath is the directory, under BaseMountPath, in which to mount
+	// the 32-bit onload libraries if there are any, or "" not to.
+	Lib32MountPath string `json:"lib32MountPath"`
+	// ProfileDir is a directory holding an onload profile, such as a mounted
+	// ConfigMap, with a file for each environment variable to set in
+	// containers given the shared or per-interface resources, or "" for
+	// none.
+	ProfileDir string `json:"profileDir"`
+	// ToolMounts are onload diagnostic tools, eg. onload_stackdump, to mount
+	// in BinMountPath whether or not MountOnload is set.
+	ToolMounts []string `json:"toolMounts"`
+	NeedNic    bool     `json:"needNic"`
+	// SysfsRoot is where sysfs is mounted, used to discover the NICs unless
+	// Discoverer is set.
+	SysfsRoot string `json:"sysfsRoot"`
+	// Discoverer finds the NICs, or is nil to look for them in SysfsRoot.
+	Discoverer NicDiscoverer `json:"-"`
+	// DevRoot is the directory containing /dev, used to check that the onload
+	// device nodes exist.
+	DevRoot string `json:"devRoot"`
+	// PerInterface advertises a resource for each sfc interface, which only
+	// accelerates that interface, instead of the shared resource.
+	PerInterface bool `json:"perInterface"`
+	// CDI gives containers onload by writing a Container Device Interface
+	// spec, and returning its device from Allocate, instead of returning the
+	// device nodes, mounts and environment directly.
+	CDI bool `json:"cdi"`
+	// TCPDirect advertises a resource giving containers TCPDirect.
+	TCPDirect bool `json:"tcpdirect"`
+	// EFVI advertises a resource giving containers ef_vi.
+	EFVI bool `json:"efvi"`
+	// CDISpecDir is the directory where the CDI spec is written.
+	CDISpecDir string `json:"cdiSpecDir"`
+	// MetricsAddress is the address to serve metrics on, or "" not to.
+	MetricsAddress string `json:"metricsAddress"`
+	// DebugAddress is the address to serve what the device plugin is doing
+	// on, for debugging, or "" not to. It should be local to the node.
+	DebugAddress string `json:"debugAddress"`
+	// PodResourcesSocket is the kubelet's PodResources API socket, used to
+	// track which pods hold our devices, or "" not to.
+	PodResourcesSocket string `json:"podResourcesSocket"`
+	// NodeName is the name of this node, which is annotated with the pods
+	// holding our devices, or "" not to.
+	NodeName string `json:"nodeName"`
+	// ConfigFile is a YAML or JSON file whose settings override the others.
+	// It is watched, and changes to some settings are applied as they are
+	// made; see reloadConfig.
+	ConfigFile string `json:"-"`
 }
 
 // Ideally this would be const, but go doesn't support const structs.
 var DefaultConfig = NicManagerConfig{
-	MaxPodsPerNode: 100,
-	SetPreload:     true,
-	MountOnload:    false,
-	HostPathPrefix: "/opt/onload",
-	BaseMountPath:  "/opt/onload",
-	BinMountPath:   "/usr/bin",
-	LibMountPath:   "/usr/lib64",
-	NeedNic:        true,
+	ResourceName:       defaultResourceName,
+	MaxPodsPerNode:     100,
+	SetPreload:         true,
+	MountOnload:        false,
+	HostPathPrefix:     "/opt/onload",
+	BaseMountPath:      "/opt/onload",
+	BinMountPath:       "/usr/bin",
+	LibMountPath:       "/usr/lib64",
+	ExtraLibMountPaths: []string{},
+	Lib32MountPath:     "/usr/lib",
+	ProfileDir:         "",
+	ToolMounts:         []string{},
+	NeedNic:            true,
+	SysfsRoot:          "/sys",
+	Discoverer:         nil,
+	DevRoot:            "/",
+	PerInterface:       false,
+	CDI:                false,
+	TCPDirect:          false,
+	EFVI:               false,
+	CDISpecDir:         "/var/run/cdi",
+	MetricsAddress:     "",
+	DebugAddress:       "",
+	PodResourcesSocket: "/var/lib/kubelet/pod-resources/kubelet.sock",
+	NodeName:           "",
+	ConfigFile:         "",
 }
 
 // NicManager holds all the state required by the device plugin
 type NicManager struct {
-	// interfaces is used to check the presence of any sfc nics on the node.
-	// Currently it is just used as a check for existence and no additional
-	// logic takes place.
-	interfaces  []string
+	// nics are the sfc interfaces on the node. Their link state and NUMA
+	// locality determine the health and topology of the devices.
+	nics        []Nic
 	deviceFiles []*pluginapi.DeviceSpec
 	mounts      []*pluginapi.Mount
-	devices     []*pluginapi.Device
 	envs        map[string]string
-	rpcServer   *RPCServer
-	wg          sync.WaitGroup
-	config      NicManagerConfig
+	// profile holds the environment variables of the onload profile.
+	profile map[string]string
+	// apiResponses holds the response to Allocate for each API resource,
+	// keyed by its name.
+	apiResponses map[string]*pluginapi.ContainerAllocateResponse
+	config       NicManagerConfig
+	// useCDI is true once the CDI spec has been written.
+	useCDI bool
+	// baseConfig is the config that the config file is applied over.
+	baseConfig NicManagerConfig
+	// configContents is the contents of the config file last loaded.
+	configContents []byte
+
+	// mu protects the state that changes while the device plugin runs: nics,
+	// resources, devices, reasons, changed and rpcServers as NICs come and
+	// go; deviceFiles, mounts, envs, apiResponses, config and useCDI as the
+	// config file is reloaded; profile as the profile is reloaded; lastSends
+	// as ListAndWatch sends devices; and allocations as they are published.
+	// baseConfig and configContents are only used by the config file watcher.
+	// The devices slices are replaced rather than modified so that they can
+	// be sent to the kubelet without holding the lock.
+	mu sync.Mutex
+	// resources holds the state of each resource, keyed by the interface the
+	// resource is restricted to, "" for the shared resource, or the name of
+	// an API resource.
+	resources map[string]resourceState
+	// devices holds the devices of each resource, keyed as resources.
+	devices map[string][]*pluginapi.Device
+	// reasons holds why each unhealthy resource is unhealthy, keyed as
+	// resources.
+	reasons map[string]error
+	// changed is closed, and replaced, whenever the devices change.
+	changed chan struct{}
+	// rpcServers holds the RPC server of each resource, keyed as resources.
+	rpcServers map[string]*RPCServer
+	// lastSends holds when ListAndWatch last sent the devices of each
+	// resource, keyed as resources.
+	lastSends map[string]time.Time
+	// allocations are the containers holding our devices, as last published.
+	allocations []Allocation
+
+	// queryNics returns the sfc interfaces present on the node.
+	queryNics func() ([]Nic, error)
+	// listAllocations returns the containers holding our devices, or is nil
+	// if they aren't tracked.
+	listAllocations func(context.Context) ([]Allocation, error)
+	// publisher publishes the allocations, or is nil if they aren't
+	// published.
+	publisher allocationPublisher
+	// pluginDir is the directory holding the kubelet's socket and ours, or ""
+	// for the kubelet's default.
+	pluginDir string
 }
 
 func (manager *NicManager) GetInterfaces() []string {
-	return manager.interfaces
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return interfaceNames(manager.nics)
 }
 
 func (manager *NicManager) GetDeviceFiles() []*pluginapi.DeviceSpec {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
 	return manager.deviceFiles
 }
 
@@ -67,6 +192,25 @@ func (manager *NicManager) GetDeviceFiles() []*pluginapi.DeviceSpec {
 func NewNicManager(
 	config NicManagerConfig,
 ) (*NicManager, error) {
+	baseConfig := config
+	var configContents []byte
+	if config.ConfigFile != "" {
+		var err error
+		config, configContents, err = loadConfig(baseConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := validateConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	discoverer := config.Discoverer
+	if discoverer == nil {
+		discoverer = sysfsDiscoverer{root: config.SysfsRoot}
+	}
+	queryNics := discoverer.DiscoverNics
 	nics, err := queryNics()
 	if err != nil {
 		return nil, err
@@ -75,51 +219,143 @@ func NewNicManager(
 		return nil, errors.New("no sfc devices found")
 	}
 	manager := &NicManager{
-		interfaces: nics,
-		config:     config,
+		nics:           nics,
+		config:         config,
+		baseConfig:     baseConfig,
+		configContents: configContents,
+		queryNics:      queryNics,
+		rpcServers:     map[string]*RPCServer{},
 	}
-	manager.envs = make(map[string]string)
 	manager.initDevices()
-
-	if manager.config.SetPreload && manager.config.MountOnload {
-		return nil, errors.New("setting both usePreload and mountOnload is not supported")
-	}
+	manager.initProfile()
 	manager.initMounts()
+	manager.initCDI()
+	manager.initAllocations()
 
-	manager.rpcServer = NewRPCServer(manager)
 	return manager, nil
 }
 
 // Initialises the set of devices to advertise to kubernetes
 func (manager *NicManager) initDevices() {
-	manager.devices = []*pluginapi.Device{}
-	fmt.Println(manager.interfaces)
+	manager.resources = map[string]resourceState{}
+	manager.devices = map[string][]*pluginapi.Device{}
+	manager.setResources(manager.resourceStates(manager.nics))
+	manager.changed = make(chan struct{})
+}
+
+// Returns the set of devices to advertise to kubernetes, all with the given
+// health, partitioned across the given NUMA nodes.
+func (manager *NicManager) makeDevices(health string, numaNodes []int) []*pluginapi.Device {
+	devices := []*pluginapi.Device{}
 	for i := 0; i < manager.config.MaxPodsPerNode; i++ {
 		name := fmt.Sprintf("sfc-%v", i)
 		device := &pluginapi.Device{
-			ID:     name,
-			Health: pluginapi.Healthy,
+			ID:       name,
+			Health:   health,
+			Topology: deviceTopology(i, numaNodes),
 		}
-		manager.devices = append(manager.devices, device)
+		devices = append(devices, device)
 	}
+	return devices
 }
 
-// CheckNics checks that the NICs in the system are still healthy
-func (manager *NicManager) CheckNics() {
-	interfaces, err := queryNics()
-	if err != nil {
-		glog.Fatalf("Failed to query nics (%v)", err)
-	}
-	if !reflect.DeepEqual(interfaces, manager.interfaces) {
-		glog.Fatalf("SFC interfaces on host have changed (%s -> %s)",
-			manager.interfaces, interfaces)
+// getDevices returns the devices of the shared resource.
+func (manager *NicManager) getDevices() []*pluginapi.Device {
+	devices, _ := manager.watchDevices("")
+	return devices
+}
+
+// watchDevices returns the current devices of the resource restricted to the
+// given interface, or of the shared resource if it is "", and a channel that
+// is closed when any devices next change.
+func (manager *NicManager) watchDevices(iface string) ([]*pluginapi.Device, <-chan struct{}) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.devices[iface], manager.changed
+}
+
+// runServers runs an RPC server for each resource, starting new ones as
+// interfaces appear. Only returns when a server fails, or ctx is cancelled,
+// once every server has stopped.
+func (manager *NicManager) runServers(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error)
+	for {
+		manager.mu.Lock()
+		changed := manager.changed
+		for iface := range manager.resources {
+			if _, ok := manager.rpcServers[iface]; ok {
+				continue
+			}
+			rpc := NewRPCServer(manager, iface)
+			manager.rpcServers[iface] = rpc
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := rpc.Run(ctx)
+				select {
+				case errs <- fmt.Errorf("%s: %w", rpc.resourceName, err):
+				case <-ctx.Done():
+				}
+			}()
+		}
+		manager.mu.Unlock()
+
+		select {
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
 	}
 }
 
-// Run runs the device plugin, blocking forever
-func (manager *NicManager) Run() {
-	go manager.rpcServer.Serve()
-	manager.rpcServer.WaitUntilUp()
-	manager.rpcServer.Register()
-	manager.wg.Wait() // Blocks forever or until rpc server hits a fatal error
+// Run runs the device plugin until ctx is cancelled, when it stops the RPC
+// servers, removing their sockets, and returns nil. Returns an error if an
+// RPC server fails. Either way, everything started has stopped by the time it
+// returns.
+func (manager *NicManager) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	background := []func(context.Context){manager.monitorHealth}
+	if manager.config.ConfigFile != "" {
+		background = append(background, manager.watchConfig)
+	}
+	if manager.config.ProfileDir != "" {
+		background = append(background, manager.watchProfile)
+	}
+	if manager.config.MetricsAddress != "" {
+		background = append(background, manager.serveMetrics)
+	}
+	if manager.config.DebugAddress != "" {
+		background = append(background, manager.serveDebug)
+	}
+	if manager.listAllocations != nil {
+		background = append(background, manager.trackAllocations)
+	}
+	for _, run := range background {
+		wg.Add(1)
+		go func(run func(context.Context)) {
+			defer wg.Done()
+			run(ctx)
+		}(run)
+	}
+
+	err := manager.runServers(ctx)
+	if manager.listAllocations != nil {
+		manager.finalAllocations()
+	}
+	if ctx.Err() != nil {
+		glog.Info("Device plugin stopped")
+		return nil
+	}
+	return fmt.Errorf("device plugin RPC server failed (%w)", err)
 }
@@ -3,12 +3,15 @@
 package deviceplugin
 
 import (
+	"errors"
 	"os"
 	"path"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
+	"github.com/onsi/gomega/types"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
 var _ = Describe("Testing command line options", func() {
@@ -17,6 +20,7 @@ var _ = Describe("Testing command line options", func() {
 	BeforeEach(func() {
 		config = DefaultConfig
 		config.NeedNic = false
+		config.SysfsRoot = newFakeSysfs()
 	})
 
 	It("should mount onload libraries", func() {
@@ -44,7 +48,7 @@ var _ = Describe("Testing command line options", func() {
 		man, err := NewNicManager(config)
 		Expect(err).Should(Succeed())
 		Expect(man.config.MaxPodsPerNode).Should(Equal(num))
-		Expect(len(man.devices)).Should(Equal(num))
+		Expect(len(man.getDevices())).Should(Equal(num))
 	},
 		Entry( /*It*/ "should set it to 0", 0),
 		Entry( /*It*/ "should set it to 1", 1),
@@ -88,3 +92,81 @@ var _ = Describe("Testing command line options", func() {
 		Expect(err).ShouldNot(Succeed())
 	})
 })
+
+var _ = Describe("Testing changes to the NICs", func() {
+	var (
+		manager  *NicManager
+		nics     []Nic
+		queryErr error
+	)
+
+	BeforeEach(func() {
+		nics = []Nic{fakeNic("enp2s0f0", -1, true), fakeNic("enp2s0f1", -1, true)}
+		queryErr = nil
+
+		manager = &NicManager{
+			nics:   nics,
+			config: newFakeHost(),
+			queryNics: func() ([]Nic, error) {
+				return nics, queryErr
+			},
+		}
+		manager.config.MaxPodsPerNode = 2
+		manager.initDevices()
+	})
+
+	allDevices := func(health string) types.GomegaMatcher {
+		return HaveEach(PointTo(MatchFields(IgnoreExtras, Fields{
+			"Health": Equal(health),
+		})))
+	}
+
+	It("should not change anything if the NICs are unchanged", func() {
+		Expect(manager.updateHealth()).Should(BeFalse())
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Healthy))
+	})
+
+	It("should follow renamed interfaces without changing the devices", func() {
+		nics = []Nic{fakeNic("enp2s0f0", -1, true), fakeNic("sfc1", -1, true)}
+		Expect(manager.updateHealth()).Should(BeFalse())
+		Expect(manager.GetInterfaces()).Should(Equal([]string{"enp2s0f0", "sfc1"}))
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Healthy))
+	})
+
+	It("should mark the devices unhealthy until an interface returns", func() {
+		nics = []Nic{}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.getDevices()).Should(HaveLen(2))
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Unhealthy))
+
+		nics = []Nic{fakeNic("enp2s0f1", -1, true)}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Healthy))
+	})
+
+	It("should mark the devices unhealthy while no link is up", func() {
+		down := fakeNic("enp2s0f0", -1, false)
+		nics = []Nic{down}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Unhealthy))
+
+		nics = []Nic{down, fakeNic("enp2s0f1", -1, true)}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Healthy))
+	})
+
+	It("should keep the devices healthy without interfaces if no NIC is needed", func() {
+		manager.config.NeedNic = false
+		nics = []Nic{}
+		Expect(manager.updateHealth()).Should(BeFalse())
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Healthy))
+	})
+
+	It("should keep the last known state if the NICs cannot be queried", func() {
+		nics = []Nic{}
+		queryErr = errors.New("query failed")
+		Expect(manager.updateHealth()).Should(BeFalse())
+		Expect(manager.GetInterfaces()).Should(HaveLen(2))
+		Expect(manager.getDevices()).Should(allDevices(pluginapi.Healthy))
+	})
+})
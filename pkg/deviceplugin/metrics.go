@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// The prefix of the names of our metrics.
+const metricsNamespace = "onload_device_plugin"
+
+// The path that metrics are served on.
+const metricsPath = "/metrics"
+
+// Metrics counting events, labelled by the resource they happened to.
+var (
+	allocationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "allocations_total",
+		Help:      "Number of Allocate requests served.",
+	}, []string{"resource"})
+	containerAllocationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_allocations_total",
+		Help:      "Number of containers given onload by Allocate requests.",
+	}, []string{"resource"})
+	preStartFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "prestart_failures_total",
+		Help:      "Number of containers stopped from starting as onload wasn't ready.",
+	}, []string{"resource"})
+	registrationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "registrations_total",
+		Help:      "Number of attempts to register with the kubelet.",
+	}, []string{"resource"})
+	registrationFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "registration_failures_total",
+		Help:      "Number of failed attempts to register with the kubelet.",
+	}, []string{"resource"})
+)
+
+// Metrics describing the current state of the NicManager, which are
+// collected from it as they are scraped.
+var (
+	devicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "devices"),
+		"Number of devices advertised to the kubelet.",
+		[]string{"resource"}, nil)
+	healthyDevicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "devices_healthy"),
+		"Number of healthy devices advertised to the kubelet.",
+		[]string{"resource"}, nil)
+	interfaceLinkUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "interface_link_up"),
+		"Whether each sfc interface discovered on the node has link up.",
+		[]string{"interface"}, nil)
+	sinceListAndWatchSendDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "seconds_since_last_list_and_watch_send"),
+		"Time since devices were last sent to the kubelet by ListAndWatch.",
+		[]string{"resource"}, nil)
+)
+
+// managerCollector collects the metrics describing the state of a NicManager.
+type managerCollector struct {
+	manager *NicManager
+}
+
+func (collector managerCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- devicesDesc
+	descs <- healthyDevicesDesc
+	descs <- interfaceLinkUpDesc
+	descs <- sinceListAndWatchSendDesc
+}
+
+func (collector managerCollector) Collect(metrics chan<- prometheus.Metric) {
+	manager := collector.manager
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	for iface, devices := range manager.devices {
+		healthy := 0
+		for _, device := range devices {
+			if device.Health == pluginapi.Healthy {
+				healthy++
+			}
+		}
+		name := manager.resourceNameFor(iface)
+		metrics <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue,
+			float64(len(devices)), name)
+		metrics <- prometheus.MustNewConstMetric(healthyDevicesDesc, prometheus.GaugeValue,
+			float64(healthy), name)
+	}
+
+	for _, nic := range manager.nics {
+		linkUp := 0.0
+		if nic.LinkUp {
+			linkUp = 1
+		}
+		metrics <- prometheus.MustNewConstMetric(interfaceLinkUpDesc, prometheus.GaugeValue,
+			linkUp, nic.Interface)
+	}
+
+	for iface, sent := range manager.lastSends {
+		metrics <- prometheus.MustNewConstMetric(sinceListAndWatchSendDesc, prometheus.GaugeValue,
+			time.Since(sent).Seconds(), manager.resourceNameFor(iface))
+	}
+}
+
+// recordSend notes that ListAndWatch has sent the devices of the resource
+// restricted to the given interface, or of the shared resource if it is "".
+func (manager *NicManager) recordSend(iface string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.lastSends == nil {
+		manager.lastSends = map[string]time.Time{}
+	}
+	manager.lastSends[iface] = time.Now()
+}
+
+// metricsHandler returns a handler serving the device plugin's metrics.
+func (manager *NicManager) metricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		allocationsTotal,
+		containerAllocationsTotal,
+		preStartFailuresTotal,
+		registrationsTotal,
+		registrationFailuresTotal,
+		managerCollector{manager: manager},
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// serveMetrics serves the device plugin's metrics over HTTP on the configured
+// address until ctx is cancelled. Failing to serve them is logged, rather than
+// stopping the device plugin.
+func (manager *NicManager) serveMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, manager.metricsHandler())
+	server := &http.Server{
+		Addr:              manager.config.MetricsAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	glog.Infof("Serving metrics on %s%s", server.Addr, metricsPath)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		glog.Errorf("Failed to serve metrics on %s (%v)", server.Addr, err)
+	}
+}
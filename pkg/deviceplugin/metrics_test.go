@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("Testing metrics", func() {
+	var manager *NicManager
+
+	BeforeEach(func() {
+		manager = &NicManager{
+			nics: []Nic{
+				{Interface: "enp2s0f0", Vendor: "0x1924", Driver: "sfc", NUMANode: -1, LinkUp: true},
+				{Interface: "enp2s0f1", Vendor: "0x1924", Driver: "sfc", NUMANode: -1, LinkUp: false},
+			},
+			config: newFakeHost(),
+		}
+		manager.queryNics = func() ([]Nic, error) {
+			return manager.nics, nil
+		}
+		manager.config.MaxPodsPerNode = 3
+		manager.initDevices()
+	})
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		manager.metricsHandler().ServeHTTP(recorder,
+			httptest.NewRequest(http.MethodGet, metricsPath, nil))
+		Expect(recorder.Code).Should(Equal(http.StatusOK))
+		return recorder.Body.String()
+	}
+
+	It("should report the devices and interfaces", func() {
+		metrics := scrape()
+		Expect(metrics).Should(ContainSubstring(
+			`onload_device_plugin_devices{resource="amd.com/onload"} 3` + "\n"))
+		Expect(metrics).Should(ContainSubstring(
+			`onload_device_plugin_devices_healthy{resource="amd.com/onload"} 3` + "\n"))
+		Expect(metrics).Should(ContainSubstring(
+			`onload_device_plugin_interface_link_up{interface="enp2s0f0"} 1` + "\n"))
+		Expect(metrics).Should(ContainSubstring(
+			`onload_device_plugin_interface_link_up{interface="enp2s0f1"} 0` + "\n"))
+		Expect(metrics).ShouldNot(ContainSubstring("seconds_since_last_list_and_watch_send"))
+	})
+
+	It("should report unhealthy devices", func() {
+		manager.config.DevRoot = GinkgoT().TempDir()
+		manager.updateHealth()
+		Expect(scrape()).Should(ContainSubstring(
+			`onload_device_plugin_devices_healthy{resource="amd.com/onload"} 0` + "\n"))
+	})
+
+	It("should count allocations", func() {
+		rpc := NewRPCServer(manager, "")
+		allocations := testutil.ToFloat64(allocationsTotal.WithLabelValues(rpc.resourceName))
+		containers := testutil.ToFloat64(containerAllocationsTotal.WithLabelValues(rpc.resourceName))
+
+		_, err := rpc.Allocate(context.Background(), &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+				{DevicesIDs: []string{"sfc-0"}},
+				{DevicesIDs: []string{"sfc-1"}},
+			},
+		})
+		Expect(err).Should(Succeed())
+
+		Expect(testutil.ToFloat64(allocationsTotal.WithLabelValues(rpc.resourceName))).
+			Should(Equal(allocations + 1))
+		Expect(testutil.ToFloat64(containerAllocationsTotal.WithLabelValues(rpc.resourceName))).
+			Should(Equal(containers + 2))
+	})
+
+	It("should report the time since ListAndWatch last sent the devices", func() {
+		manager.recordSend("")
+		Expect(scrape()).Should(MatchRegexp(
+			`onload_device_plugin_seconds_since_last_list_and_watch_send{resource="amd.com/onload"} [0-9.e-]+\n`))
+	})
+})
@@ -3,8 +3,13 @@
 package deviceplugin
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path"
+	"slices"
 	"strings"
 
 	"github.com/golang/glog"
@@ -13,6 +18,7 @@ import (
 
 const (
 	hostLib64path  = "/usr/lib64"
+	hostLib32path  = "/usr/lib"
 	hostUsrBinPath = "/usr/bin"
 )
 
@@ -35,74 +41,223 @@ var fileMounts = []string{
 	"onload",
 }
 
-// addDeviceMount arranges for this device file to be mounted inside containers
-func (manager *NicManager) addDeviceMount(path string) {
-	spec := pluginapi.DeviceSpec{
+// toolHelpers are further files, in the same directory, which diagnostic
+// tools run and so must be mounted alongside them.
+var toolHelpers = map[string][]string{
+	"onload_tcpdump": {"onload_tcpdump.bin"},
+}
+
+// Returns the spec giving containers this device file
+func newDeviceSpec(path string) *pluginapi.DeviceSpec {
+	glog.Infof("Mount %s ---> %s", path, path)
+	return &pluginapi.DeviceSpec{
 		HostPath:      path,
 		ContainerPath: path,
 		Permissions:   "mrw",
 	}
-	glog.Infof("Mount %s ---> %s", path, path)
-	manager.deviceFiles = append(manager.deviceFiles, &spec)
 }
 
-// addFileMount arranges for this host path to be mounted inside containers
-func (manager *NicManager) addFileMount(hostPath, containerPath string) {
-	spec := pluginapi.Mount{
+// Returns the read-only mount of this host path inside containers
+func newFileMount(hostPath, containerPath string) *pluginapi.Mount {
+	glog.Infof("Mount %s ---> %s", hostPath, containerPath)
+	return &pluginapi.Mount{
 		HostPath:      hostPath,
 		ContainerPath: containerPath,
 		ReadOnly:      true,
 	}
-	glog.Infof("Mount %s ---> %s", hostPath, containerPath)
-	manager.mounts = append(manager.mounts, &spec)
 }
 
-// Returns all versioned names of this library file
-func findLibraryVersions(filename, hostPathPrefix string) ([]string, error) {
-	infos, err := os.ReadDir(path.Join(hostPathPrefix, hostLib64path))
+// addDeviceMount arranges for this device file to be mounted inside containers
+func (manager *NicManager) addDeviceMount(path string) {
+	manager.deviceFiles = append(manager.deviceFiles, newDeviceSpec(path))
+}
+
+// addFileMount arranges for this host path to be mounted inside containers
+func (manager *NicManager) addFileMount(hostPath, containerPath string) {
+	manager.mounts = append(manager.mounts, newFileMount(hostPath, containerPath))
+}
+
+// The most symlinks followed when resolving a library, as in Linux.
+const maxSymlinks = 40
+
+// Returns the file that p resolves to, following symlinks. The host's onload
+// files are installed under root, so absolute symlinks are resolved under it
+// rather than in the device plugin's filesystem.
+func resolveSymlinks(root, p string) (string, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return p, nil
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			p = path.Join(root, target)
+		} else {
+			p = path.Join(path.Dir(p), target)
+		}
+	}
+	return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+}
+
+// Returns all versioned names of this library file in dir, eg. name.so and
+// name.so.1, mapped to the files under root they resolve to. Names which are
+// dangling symlinks, or which resolve to directories, are skipped, as the
+// container would fail to start if they were mounted.
+func findLibraryVersions(filename, root, dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	filenames := []string{}
+	versions := map[string]string{}
 
-	for _, info := range infos {
+	for _, entry := range entries {
+		name := entry.Name()
+		if name != filename && !strings.HasPrefix(name, filename+".") {
+			continue
+		}
+		resolved, err := resolveSymlinks(root, path.Join(dir, name))
+		if err != nil {
+			glog.Warningf("Not mounting library %s (%v)", name, err)
+			continue
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			glog.Warningf("Not mounting library %s (%v)", name, err)
+			continue
+		}
 		if info.IsDir() {
 			continue
 		}
-		name := info.Name()
-		if strings.HasPrefix(name, filename) {
-			filenames = append(filenames, name)
+		versions[name] = resolved
+	}
+	return versions, nil
+}
+
+// libraryDir is a directory of onload libraries on the host, and the
+// directories in the container to mount them in.
+type libraryDir struct {
+	hostDir       string
+	containerDirs []string
+	// optional is true if the host directory need not exist.
+	optional bool
+}
+
+// Returns the directories of onload libraries on the host and where they are
+// mounted in the container. Different distros look in different directories
+// for libraries, so the 64-bit libraries can be mounted in several. The 32-bit
+// libraries are only mounted if the user image provides them.
+func (manager *NicManager) libraryDirs() []libraryDir {
+	config := manager.config
+	lib64Dirs := []string{path.Join(config.BaseMountPath, config.LibMountPath)}
+	for _, dir := range config.ExtraLibMountPaths {
+		dir = path.Join(config.BaseMountPath, dir)
+		if !slices.Contains(lib64Dirs, dir) {
+			lib64Dirs = append(lib64Dirs, dir)
 		}
 	}
-	return filenames, nil
+
+	dirs := []libraryDir{{
+		hostDir:       path.Join(config.HostPathPrefix, hostLib64path),
+		containerDirs: lib64Dirs,
+	}}
+	if config.Lib32MountPath != "" {
+		dirs = append(dirs, libraryDir{
+			hostDir:       path.Join(config.HostPathPrefix, hostLib32path),
+			containerDirs: []string{path.Join(config.BaseMountPath, config.Lib32MountPath)},
+			optional:      true,
+		})
+	}
+	return dirs
 }
 
-// addLibraryMounts arranges for this library to be mounted inside the
+// findLibraryMounts returns the mounts of this library inside the
 // container. There are two complications here:
 //  1. The library may exist as both name.so and name.so.<version>;
-//     if so we must mount both versions
+//     if so we must mount both versions, each resolving any symlinks
 //  2. Different distros look in different directories for libraries. To be
 //     compatibile with all distros we must mount the library in multiple
 //     directories inside the container
+func (manager *NicManager) findLibraryMounts(baseFilename string) ([]*pluginapi.Mount, error) {
+	mounts := []*pluginapi.Mount{}
+	for _, dir := range manager.libraryDirs() {
+		versions, err := findLibraryVersions(baseFilename,
+			manager.config.HostPathPrefix, dir.hostDir)
+		if dir.optional && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 && !dir.optional {
+			return nil, fmt.Errorf("%s not found in %s", baseFilename, dir.hostDir)
+		}
+
+		names := []string{}
+		for name := range versions {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, containerDir := range dir.containerDirs {
+			for _, name := range names {
+				mounts = append(mounts, newFileMount(versions[name], path.Join(containerDir, name)))
+			}
+		}
+	}
+	return mounts, nil
+}
+
+// addLibraryMounts arranges for this library to be mounted inside the
+// container, as described by findLibraryMounts
 func (manager *NicManager) addLibraryMounts(baseFilename string) error {
-	filenames, err := findLibraryVersions(baseFilename,
-		manager.config.HostPathPrefix)
+	mounts, err := manager.findLibraryMounts(baseFilename)
 	if err != nil {
 		return err
 	}
-	for _, filename := range filenames {
-		manager.addFileMount(
-			path.Join(manager.config.HostPathPrefix, hostLib64path, filename),
-			path.Join(manager.config.BaseMountPath, manager.config.LibMountPath,
-				filename),
-		)
+	manager.mounts = append(manager.mounts, mounts...)
+	return nil
+}
+
+// addBinaryMount arranges for this onload binary to be mounted inside the
+// container
+func (manager *NicManager) addBinaryMount(file string) {
+	manager.addFileMount(
+		path.Join(manager.config.HostPathPrefix, hostUsrBinPath, file),
+		path.Join(manager.config.BaseMountPath, manager.config.BinMountPath, file),
+	)
+}
+
+// addToolMounts arranges for this diagnostic tool, and any helpers it runs,
+// to be mounted inside the container. The tools run in the container use the
+// onload libraries that are already mounted.
+func (manager *NicManager) addToolMounts(tool string) error {
+	files := append([]string{tool}, toolHelpers[tool]...)
+	for _, file := range files {
+		_, err := os.Stat(path.Join(manager.config.HostPathPrefix, hostUsrBinPath, file))
+		if err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		manager.addBinaryMount(file)
 	}
 	return nil
 }
 
-// Initialises the set of host files to mount in each container
+// Initialises the set of host files to mount in each container, and the
+// environment to set, replacing any previous ones. The environment starts
+// with the onload profile, which the device plugin's own settings override.
 func (manager *NicManager) initMounts() {
 	manager.deviceFiles = []*pluginapi.DeviceSpec{}
+	manager.mounts = []*pluginapi.Mount{}
+	manager.envs = maps.Clone(manager.profile)
+	if manager.envs == nil {
+		manager.envs = map[string]string{}
+	}
 
 	for _, path := range deviceMounts {
 		manager.addDeviceMount(path)
@@ -115,14 +270,24 @@ func (manager *NicManager) initMounts() {
 		}
 	}
 
+	mounted := []string{}
 	if manager.config.MountOnload {
 		for _, file := range fileMounts {
-			manager.addFileMount(
-				path.Join(manager.config.HostPathPrefix, hostUsrBinPath, file),
-				path.Join(manager.config.BaseMountPath,
-					manager.config.BinMountPath, file),
-			)
+			manager.addBinaryMount(file)
 		}
+		mounted = append(mounted, fileMounts...)
+	}
+
+	for _, tool := range manager.config.ToolMounts {
+		if slices.Contains(mounted, tool) {
+			continue
+		}
+		err := manager.addToolMounts(tool)
+		if err != nil {
+			glog.Warningf("Failed to add tool mount for %s (%v)", tool, err)
+			continue
+		}
+		mounted = append(mounted, tool)
 	}
 
 	if manager.config.SetPreload {
@@ -130,4 +295,6 @@ func (manager *NicManager) initMounts() {
 			path.Join(manager.config.BaseMountPath, manager.config.LibMountPath,
 				"libonload.so")
 	}
+
+	manager.initAPIResponses()
 }
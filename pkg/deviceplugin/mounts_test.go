@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/onsi/gomega/types"
+)
+
+var _ = Describe("Testing library and tool mounts", func() {
+	var config NicManagerConfig
+
+	BeforeEach(func() {
+		config = newFakeHost()
+	})
+
+	create := func(dir, name string) {
+		Expect(os.MkdirAll(path.Join(config.HostPathPrefix, dir), os.ModePerm)).Should(Succeed())
+		Expect(os.WriteFile(path.Join(config.HostPathPrefix, dir, name), []byte{}, 0644)).
+			Should(Succeed())
+	}
+
+	symlink := func(dir, name, target string) {
+		Expect(os.Symlink(target, path.Join(config.HostPathPrefix, dir, name))).Should(Succeed())
+	}
+
+	mount := func(hostPath, containerPath string) types.GomegaMatcher {
+		return PointTo(MatchFields(IgnoreExtras, Fields{
+			"HostPath":      Equal(hostPath),
+			"ContainerPath": Equal(containerPath),
+		}))
+	}
+
+	newManager := func() *NicManager {
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+		return manager
+	}
+
+	It("should mount the libraries in every configured directory", func() {
+		config.ExtraLibMountPaths = []string{"/usr/lib/x86_64-linux-gnu", config.LibMountPath}
+		manager := newManager()
+
+		hostPath := path.Join(config.HostPathPrefix, hostLib64path, "libonload.so")
+		Expect(manager.mounts).Should(ContainElement(
+			mount(hostPath, "/opt/onload/usr/lib64/libonload.so")))
+		Expect(manager.mounts).Should(ContainElement(
+			mount(hostPath, "/opt/onload/usr/lib/x86_64-linux-gnu/libonload.so")))
+		Expect(manager.mounts).Should(HaveLen(2 * len(libraryMounts)))
+	})
+
+	It("should mount the 32-bit libraries only when present", func() {
+		manager := newManager()
+		Expect(manager.mounts).ShouldNot(ContainElement(
+			HaveField("ContainerPath", HavePrefix("/opt/onload/usr/lib/"))))
+
+		create(hostLib32path, "libonload.so")
+		manager = newManager()
+		Expect(manager.mounts).Should(ContainElement(mount(
+			path.Join(config.HostPathPrefix, hostLib32path, "libonload.so"),
+			"/opt/onload/usr/lib/libonload.so")))
+
+		config.Lib32MountPath = ""
+		manager = newManager()
+		Expect(manager.mounts).ShouldNot(ContainElement(
+			HaveField("ContainerPath", HavePrefix("/opt/onload/usr/lib/"))))
+	})
+
+	It("should mount each version of a library as the file it links to", func() {
+		create(hostLib64path, "libonload.so.1.0.0")
+		symlink(hostLib64path, "libonload.so.1", "libonload.so.1.0.0")
+		// Absolute links are relative to where onload is installed on the host.
+		symlink(hostLib64path, "libonload.so.1.0", path.Join(hostLib64path, "libonload.so.1"))
+		manager := newManager()
+
+		target := path.Join(config.HostPathPrefix, hostLib64path, "libonload.so.1.0.0")
+		Expect(manager.mounts).Should(ContainElement(
+			mount(target, "/opt/onload/usr/lib64/libonload.so.1")))
+		Expect(manager.mounts).Should(ContainElement(
+			mount(target, "/opt/onload/usr/lib64/libonload.so.1.0")))
+		Expect(manager.mounts).Should(ContainElement(
+			mount(target, "/opt/onload/usr/lib64/libonload.so.1.0.0")))
+	})
+
+	It("should skip dangling links and other libraries", func() {
+		symlink(hostLib64path, "libonload.so.2", "libonload.so.2.0.0")
+		create(hostLib64path, "libonload.so_old")
+		manager := newManager()
+
+		Expect(manager.mounts).ShouldNot(ContainElement(
+			HaveField("ContainerPath", HaveSuffix("libonload.so.2"))))
+		Expect(manager.mounts).ShouldNot(ContainElement(
+			HaveField("ContainerPath", HaveSuffix("libonload.so_old"))))
+		Expect(manager.mounts).Should(HaveLen(len(libraryMounts)))
+	})
+
+	It("should mount the tools with their helpers", func() {
+		for _, file := range []string{"onload_stackdump", "onload_tcpdump", "onload_tcpdump.bin"} {
+			create(hostUsrBinPath, file)
+		}
+		config.ToolMounts = []string{"onload_stackdump", "onload_tcpdump"}
+		manager := newManager()
+
+		for _, file := range []string{"onload_stackdump", "onload_tcpdump", "onload_tcpdump.bin"} {
+			Expect(manager.mounts).Should(ContainElement(mount(
+				path.Join(config.HostPathPrefix, hostUsrBinPath, file),
+				path.Join("/opt/onload/usr/bin", file))))
+		}
+	})
+
+	It("should skip tools which aren't installed", func() {
+		create(hostUsrBinPath, "onload_tcpdump")
+		config.ToolMounts = []string{"onload_fuser", "onload_tcpdump"}
+		manager := newManager()
+
+		Expect(manager.mounts).ShouldNot(ContainElement(
+			HaveField("ContainerPath", HavePrefix("/opt/onload/usr/bin/"))))
+	})
+
+	It("should mount tools independently of the onload script", func() {
+		create(hostUsrBinPath, "onload")
+		create(hostUsrBinPath, "orm_json")
+		config.SetPreload = false
+		config.MountOnload = true
+		config.ToolMounts = []string{"onload", "orm_json"}
+		manager := newManager()
+
+		Expect(manager.mounts).Should(ContainElement(
+			HaveField("ContainerPath", "/opt/onload/usr/bin/orm_json")))
+		Expect(manager.mounts).Should(HaveLen(len(libraryMounts) + 2))
+
+		config.SetPreload = true
+		config.MountOnload = false
+		manager = newManager()
+		Expect(manager.envs).Should(HaveKey("LD_PRELOAD"))
+		Expect(manager.mounts).Should(ContainElement(
+			HaveField("ContainerPath", "/opt/onload/usr/bin/onload")))
+		Expect(manager.mounts).Should(HaveLen(len(libraryMounts) + 2))
+	})
+})
@@ -3,69 +3,162 @@
 package deviceplugin
 
 import (
+	"errors"
+	"io/fs"
 	"os"
-	"os/exec"
-	"regexp"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
 )
 
-// Takes the output from lshw and returns the device name for each solarflare
-// device.
-func parseOutput(output string) []string {
-	// "lshw -short -class network" sample output:
-	// H/W path            Device     Class          Description
-	// =========================================================
-	// /0/100/1b/0         enp2s0f0   network        XtremeScale SFC9250 10/25/40/50/100G Ethernet Controller
-	// /0/100/1b/0.1       enp2s0f1   network        XtremeScale SFC9250 10/25/40/50/100G Ethernet Controller
-	// /0/100/1c.1/0       eno1       network        NetXtreme BCM5720 Gigabit Ethernet PCIe
-	// /0/100/1c.1/0.1     eno2       network        NetXtreme BCM5720 Gigabit Ethernet PCIe
-
-	lines := strings.Split(output, "\n")
-
-	var interfaces []string
-
-	// Assume that we are running as root, if not then we would have to skip
-	// an additional line at the start of the output
-	skip_lines := 2
-	end_lines := 1
-	if os.Geteuid() != 0 {
-		skip_lines = 3
-		end_lines = 2
+// PCI vendor IDs of the NICs that Onload can accelerate.
+var supportedVendors = []string{
+	"0x1924", // Solarflare
+	"0x10ee", // Xilinx
+}
+
+// Kernel drivers that must be bound to the NICs for Onload to use them.
+var supportedDrivers = []string{
+	"sfc",
+	"xilinx_efct",
+}
+
+// Nic describes a network interface that Onload can accelerate.
+type Nic struct {
+	// Interface is the name of the network interface, eg. enp2s0f0.
+	Interface string `json:"interface"`
+	// Vendor is the PCI vendor ID, eg. 0x1924.
+	Vendor string `json:"vendor"`
+	// Driver is the name of the bound kernel driver, eg. sfc.
+	Driver string `json:"driver"`
+	// PCIAddress is the PCI address of the device, eg. 0000:02:00.0.
+	PCIAddress string `json:"pciAddress"`
+	// NUMANode is the NUMA node local to the device, or -1 if unknown.
+	NUMANode int `json:"numaNode"`
+	// LinkUp is true if the operational state of the interface is up.
+	LinkUp bool `json:"linkUp"`
+}
+
+// NicDiscoverer finds the network interfaces that Onload can accelerate.
+type NicDiscoverer interface {
+	// DiscoverNics returns the interfaces present on the node, ordered by
+	// interface name.
+	DiscoverNics() ([]Nic, error)
+}
+
+// sysfsDiscoverer discovers the NICs by looking in sysfs.
+type sysfsDiscoverer struct {
+	// root is where sysfs is mounted.
+	root string
+}
+
+func (discoverer sysfsDiscoverer) DiscoverNics() ([]Nic, error) {
+	return discoverNics(discoverer.root)
+}
+
+// Returns the trimmed contents of a sysfs attribute.
+func readSysfsFile(path string) (string, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+// Returns a description of the given interface, or nil if it is not one that
+// Onload can accelerate. Interfaces without a device, such as bonds and
+// VLANs, are ignored.
+func inspectInterface(netDir string, name string) (*Nic, error) {
+	deviceDir := filepath.Join(netDir, name, "device")
+
+	pciPath, err := filepath.EvalSymlinks(deviceDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	vendor, err := readSysfsFile(filepath.Join(deviceDir, "vendor"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Not a PCI device.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(supportedVendors, vendor) {
+		return nil, nil
+	}
+
+	driverPath, err := filepath.EvalSymlinks(filepath.Join(deviceDir, "driver"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// No driver is bound.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	driver := filepath.Base(driverPath)
+	if !slices.Contains(supportedDrivers, driver) {
+		return nil, nil
 	}
 
-	for _, line := range lines[skip_lines : len(lines)-end_lines] {
-		// This regex makes the assumption that all interface names only
-		// contain either lowercase letters or numbers. If that is not true,
-		// then this should be updated to reflect that.
-		r := regexp.MustCompile("([a-z0-9]+) *network *.*SFC")
-		out := r.FindStringSubmatch(line)
-		if out != nil {
-			// It is safe to access out[1] here since the return value of
-			// FindStringSubmatch is an array where the first value is the
-			// whole string and any subsequent values are the submatches.
-			// In this case since there is a submatch that should match the
-			// device name if FindStringSubmatch returns non-nil then there
-			// will be at least 2 elements in the return array.
-			interfaces = append(interfaces, out[1])
+	nic := &Nic{
+		Interface:  name,
+		Vendor:     vendor,
+		Driver:     driver,
+		PCIAddress: filepath.Base(pciPath),
+		NUMANode:   -1,
+	}
+
+	numaNode, err := readSysfsFile(filepath.Join(deviceDir, "numa_node"))
+	if err == nil {
+		nic.NUMANode, err = strconv.Atoi(numaNode)
+		if err != nil {
+			glog.Warningf("Invalid NUMA node %q for %s (%v)", numaNode, name, err)
+			nic.NUMANode = -1
 		}
 	}
-	return interfaces
+
+	operState, err := readSysfsFile(filepath.Join(netDir, name, "operstate"))
+	if err == nil {
+		nic.LinkUp = operState == "up"
+	}
+
+	return nic, nil
 }
 
-// Returns a list of the Solarflare interfaces present on the node
-func queryNics() ([]string, error) {
-	// Depending on what information we are looking for in the output I think it
-	// is quite tempting to retrieve the information in a json format, then
-	// parse this using golang's built-in features.
-	bytes, err := exec.Command("lshw", "-short", "-class", "network").CombinedOutput()
-	output := string(bytes)
+// Returns the network interfaces that Onload can accelerate, as found in
+// class/net under the given sysfs root, ordered by interface name.
+func discoverNics(sysfsRoot string) ([]Nic, error) {
+	netDir := filepath.Join(sysfsRoot, "class", "net")
+	entries, err := os.ReadDir(netDir)
 	if err != nil {
-		glog.Error(output)
-		glog.Errorf("error while listing sfc devices : %v", err)
+		glog.Errorf("error while listing network interfaces : %v", err)
 		return nil, err
 	}
-	interfaces := parseOutput(output)
-	return interfaces, nil
+
+	nics := []Nic{}
+	for _, entry := range entries {
+		nic, err := inspectInterface(netDir, entry.Name())
+		if err != nil {
+			// The interface may have gone away while we looked at it.
+			glog.Warningf("Failed to inspect interface %s (%v)", entry.Name(), err)
+			continue
+		}
+		if nic != nil {
+			nics = append(nics, *nic)
+		}
+	}
+	return nics, nil
+}
+
+// Returns the names of the given interfaces.
+func interfaceNames(nics []Nic) []string {
+	names := []string{}
+	for _, nic := range nics {
+		names = append(names, nic.Interface)
+	}
+	return names
 }
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeInterface describes a network interface to create in a fake sysfs tree.
+type fakeInterface struct {
+	name       string
+	pciAddress string // No device if empty.
+	vendor     string
+	driver     string // No driver bound if empty.
+	numaNode   string
+	operState  string
+}
+
+// newFakeSysfs returns the root of a sysfs tree containing the interfaces,
+// laid out with the same symlinks as the real thing.
+func newFakeSysfs(interfaces ...fakeInterface) string {
+	root := GinkgoT().TempDir()
+	netDir := filepath.Join(root, "class", "net")
+	Expect(os.MkdirAll(netDir, os.ModePerm)).Should(Succeed())
+	for _, iface := range interfaces {
+		addFakeInterface(root, iface)
+	}
+	return root
+}
+
+func addFakeInterface(root string, iface fakeInterface) {
+	write := func(path string, contents string) {
+		Expect(os.WriteFile(path, []byte(contents+"\n"), 0644)).Should(Succeed())
+	}
+
+	ifaceDir := filepath.Join(root, "devices", "virtual", "net", iface.name)
+	if iface.pciAddress != "" {
+		pciDir := filepath.Join(root, "devices", "pci0000:00", iface.pciAddress)
+		ifaceDir = filepath.Join(pciDir, "net", iface.name)
+		Expect(os.MkdirAll(ifaceDir, os.ModePerm)).Should(Succeed())
+		Expect(os.Symlink(pciDir, filepath.Join(ifaceDir, "device"))).Should(Succeed())
+
+		write(filepath.Join(pciDir, "vendor"), iface.vendor)
+		if iface.numaNode != "" {
+			write(filepath.Join(pciDir, "numa_node"), iface.numaNode)
+		}
+		if iface.driver != "" {
+			driverDir := filepath.Join(root, "bus", "pci", "drivers", iface.driver)
+			Expect(os.MkdirAll(driverDir, os.ModePerm)).Should(Succeed())
+			Expect(os.Symlink(driverDir, filepath.Join(pciDir, "driver"))).Should(Succeed())
+		}
+	}
+	Expect(os.MkdirAll(ifaceDir, os.ModePerm)).Should(Succeed())
+	write(filepath.Join(ifaceDir, "operstate"), iface.operState)
+
+	Expect(os.Symlink(ifaceDir, filepath.Join(root, "class", "net", iface.name))).Should(Succeed())
+}
+
+var _ = Describe("Testing NIC discovery", func() {
+	It("should only find interfaces of supported NICs", func() {
+		root := newFakeSysfs(
+			fakeInterface{name: "lo", operState: "unknown"},
+			fakeInterface{name: "eno1", pciAddress: "0000:01:00.0",
+				vendor: "0x14e4", driver: "tg3", numaNode: "0", operState: "up"},
+			fakeInterface{name: "enp2s0f0", pciAddress: "0000:02:00.0",
+				vendor: "0x1924", driver: "sfc", numaNode: "0", operState: "up"},
+			fakeInterface{name: "enp2s0f1", pciAddress: "0000:02:00.1",
+				vendor: "0x1924", driver: "sfc", numaNode: "0", operState: "down"},
+			fakeInterface{name: "enp3s0", pciAddress: "0000:03:00.0",
+				vendor: "0x10ee", driver: "xilinx_efct", numaNode: "1", operState: "up"},
+		)
+
+		Expect(discoverNics(root)).Should(Equal([]Nic{
+			{Interface: "enp2s0f0", Vendor: "0x1924", Driver: "sfc",
+				PCIAddress: "0000:02:00.0", NUMANode: 0, LinkUp: true},
+			{Interface: "enp2s0f1", Vendor: "0x1924", Driver: "sfc",
+				PCIAddress: "0000:02:00.1", NUMANode: 0, LinkUp: false},
+			{Interface: "enp3s0", Vendor: "0x10ee", Driver: "xilinx_efct",
+				PCIAddress: "0000:03:00.0", NUMANode: 1, LinkUp: true},
+		}))
+	})
+
+	It("should ignore NICs without a supported driver bound", func() {
+		root := newFakeSysfs(
+			fakeInterface{name: "enp2s0f0", pciAddress: "0000:02:00.0",
+				vendor: "0x1924", operState: "down"},
+			fakeInterface{name: "enp2s0f1", pciAddress: "0000:02:00.1",
+				vendor: "0x1924", driver: "vfio-pci", operState: "down"},
+		)
+
+		Expect(discoverNics(root)).Should(BeEmpty())
+	})
+
+	It("should report an unknown NUMA node", func() {
+		root := newFakeSysfs(
+			fakeInterface{name: "enp2s0f0", pciAddress: "0000:02:00.0",
+				vendor: "0x1924", driver: "sfc", numaNode: "-1", operState: "up"},
+			fakeInterface{name: "enp2s0f1", pciAddress: "0000:02:00.1",
+				vendor: "0x1924", driver: "sfc", operState: "up"},
+		)
+
+		nics, err := discoverNics(root)
+		Expect(err).Should(Succeed())
+		Expect(nics).Should(HaveLen(2))
+		Expect(nics[0].NUMANode).Should(Equal(-1))
+		Expect(nics[1].NUMANode).Should(Equal(-1))
+	})
+
+	It("should fail without a sysfs tree", func() {
+		_, err := discoverNics(filepath.Join(GinkgoT().TempDir(), "missing"))
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// loadProfile returns the environment variables of the onload profile in the
+// given directory, which holds a file for each variable named after it, as a
+// mounted ConfigMap does. Files whose names aren't valid environment variable
+// names are skipped, as are the hidden files of a mounted ConfigMap.
+func loadProfile(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := map[string]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			glog.Warningf("Ignoring %s in onload profile %s (%s)", name, dir,
+				strings.Join(errs, ", "))
+			continue
+		}
+		// The files of a mounted ConfigMap are symlinks, so follow them.
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		value, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		profile[name] = strings.TrimSpace(string(value))
+	}
+	return profile, nil
+}
+
+// initProfile loads the onload profile, if there is one. A profile which
+// can't be loaded is logged and left empty, rather than stopping onload from
+// being allocated.
+func (manager *NicManager) initProfile() {
+	manager.profile = map[string]string{}
+	dir := manager.config.ProfileDir
+	if dir == "" {
+		return
+	}
+	profile, err := loadProfile(dir)
+	if err != nil {
+		glog.Warningf("Failed to load onload profile %s (%v)", dir, err)
+		return
+	}
+	glog.Infof("Loaded onload profile %s %v", dir, profile)
+	manager.profile = profile
+}
+
+// checkProfile reloads the onload profile in the given directory, applying it
+// to subsequent Allocate calls if it has changed. A profile which can't be
+// loaded, probably as it is part way through an update, is ignored.
+func (manager *NicManager) checkProfile(dir string) {
+	profile, err := loadProfile(dir)
+	if err != nil {
+		glog.V(2).Infof("Failed to load onload profile %s (%v)", dir, err)
+		return
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if reflect.DeepEqual(profile, manager.profile) {
+		return
+	}
+	glog.Infof("Reloaded onload profile %s %v", dir, profile)
+	manager.profile = profile
+	manager.updateAllocateResponses()
+}
+
+// watchProfile reloads the onload profile whenever it changes, until ctx is
+// cancelled. As with the config file, the directory is watched, as the
+// kubelet updates a mounted ConfigMap by swapping a symlink.
+func (manager *NicManager) watchProfile(ctx context.Context) {
+	manager.mu.Lock()
+	dir := manager.config.ProfileDir
+	manager.mu.Unlock()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Errorf("Failed to create profile watcher, not reloading profile (%v)", err)
+		return
+	}
+	defer watcher.Close()
+
+	err = watcher.Add(dir)
+	if err != nil {
+		glog.Errorf("Failed to watch %s, not reloading profile (%v)", dir, err)
+		return
+	}
+
+	// Check for changes made before the watch started.
+	manager.checkProfile(dir)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-watcher.Errors:
+			glog.Warningf("Profile watcher failed (%v)", err)
+		case <-watcher.Events:
+			manager.checkProfile(dir)
+		}
+	}
+}
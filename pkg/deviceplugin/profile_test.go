@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Testing onload profiles", func() {
+	var (
+		config  NicManagerConfig
+		updates int
+	)
+
+	// writeProfile lays out the profile as the kubelet does a mounted
+	// ConfigMap, swapping the ..data symlink to a new directory on each
+	// update.
+	writeProfile := func(data map[string]string) {
+		updates++
+		version := fmt.Sprintf("..version%d", updates)
+		Expect(os.Mkdir(filepath.Join(config.ProfileDir, version), 0755)).Should(Succeed())
+		for name, value := range data {
+			Expect(os.WriteFile(filepath.Join(config.ProfileDir, version, name), []byte(value), 0644)).
+				Should(Succeed())
+			link := filepath.Join(config.ProfileDir, name)
+			if _, err := os.Lstat(link); err != nil {
+				Expect(os.Symlink(filepath.Join("..data", name), link)).Should(Succeed())
+			}
+		}
+		tmp := filepath.Join(config.ProfileDir, "..data_tmp")
+		Expect(os.Symlink(version, tmp)).Should(Succeed())
+		Expect(os.Rename(tmp, filepath.Join(config.ProfileDir, "..data"))).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		config = newFakeHost()
+		config.ProfileDir = GinkgoT().TempDir()
+		updates = 0
+	})
+
+	It("should read the variables of a mounted ConfigMap", func() {
+		writeProfile(map[string]string{
+			"EF_POLL_USEC":          "100000",
+			"EF_TCP_FASTSTART_INIT": "0\n",
+			"1_NOT_A_VARIABLE":      "1",
+		})
+
+		Expect(loadProfile(config.ProfileDir)).Should(Equal(map[string]string{
+			"EF_POLL_USEC":          "100000",
+			"EF_TCP_FASTSTART_INIT": "0",
+		}))
+	})
+
+	It("should set the profile's variables in containers", func() {
+		writeProfile(map[string]string{
+			"EF_POLL_USEC": "100000",
+			"LD_PRELOAD":   "libother.so",
+		})
+		config.PerInterface = true
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(allocate(manager, "enp2s0f0").Envs).Should(Equal(map[string]string{
+			"EF_POLL_USEC":           "100000",
+			"LD_PRELOAD":             "/opt/onload/usr/lib64/libonload.so",
+			"EF_INTERFACE_WHITELIST": "enp2s0f0",
+		}))
+	})
+
+	It("should not set the profile's variables for API resources", func() {
+		writeProfile(map[string]string{"EF_POLL_USEC": "100000"})
+		config.EFVI = true
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(allocate(manager, "amd.com/efvi").Envs).Should(BeEmpty())
+	})
+
+	It("should start without the profile", func() {
+		config.ProfileDir = filepath.Join(config.ProfileDir, "missing")
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		Expect(allocate(manager, "").Envs).Should(Equal(map[string]string{
+			"LD_PRELOAD": "/opt/onload/usr/lib64/libonload.so",
+		}))
+	})
+
+	It("should apply changes to the profile to new allocations", func() {
+		writeProfile(map[string]string{"EF_POLL_USEC": "100000"})
+		manager, err := NewNicManager(config)
+		Expect(err).Should(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			manager.watchProfile(ctx)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+
+		writeProfile(map[string]string{"EF_POLL_USEC": "0"})
+		Eventually(func() map[string]string {
+			return allocate(manager, "").Envs
+		}).Should(HaveKeyWithValue("EF_POLL_USEC", "0"))
+	})
+})
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"slices"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The environment variable which restricts onload to accelerating the listed
+// interfaces.
+const interfaceWhitelistEnv = "EF_INTERFACE_WHITELIST"
+
+// resourceState determines the devices advertised for a resource.
+type resourceState struct {
+	health    string
+	numaNodes []int
+}
+
+// Returns the name of the resource restricted to the given interface, of
+// the shared resource if it is "", or of the API resource with that name.
+func (manager *NicManager) resourceNameFor(iface string) string {
+	if iface == "" {
+		return manager.config.ResourceName
+	}
+	if res, ok := apiResourceFor(iface); ok {
+		return res.name
+	}
+	return manager.config.ResourceName + "-" + iface
+}
+
+// resourceStates returns the state each resource should have given the NICs,
+// along with why any unhealthy resource is unhealthy. In per-interface mode
+// there is a resource for each interface, including any that have since gone
+// away, as the kubelet can only be told that their devices are unhealthy.
+// Each enabled API resource is shared by all interfaces.
+func (manager *NicManager) resourceStates(nics []Nic) (map[string]resourceState, map[string]error) {
+	states := map[string]resourceState{}
+	reasons := map[string]error{}
+
+	for _, res := range enabledAPIResources(manager.config) {
+		err := manager.checkAPIHealth(res, nics)
+		states[res.name] = resourceState{health: healthOf(err), numaNodes: numaNodesOf(nics)}
+		reasons[res.name] = err
+	}
+
+	if !manager.config.PerInterface {
+		err := manager.checkHealth(nics)
+		states[""] = resourceState{health: healthOf(err), numaNodes: numaNodesOf(nics)}
+		reasons[""] = err
+		return states, reasons
+	}
+
+	ifaces := []string{}
+	for iface := range manager.resources {
+		if _, ok := apiResourceFor(iface); !ok {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	for _, nic := range nics {
+		if slices.Contains(ifaces, nic.Interface) {
+			continue
+		}
+		errs := validation.IsQualifiedName(manager.resourceNameFor(nic.Interface))
+		if len(errs) > 0 {
+			glog.V(2).Infof("Not advertising a resource for interface %s (%v)",
+				nic.Interface, errs)
+			continue
+		}
+		ifaces = append(ifaces, nic.Interface)
+	}
+
+	for _, iface := range ifaces {
+		err := manager.checkInterfaceHealth(iface, nics)
+		state := resourceState{health: healthOf(err), numaNodes: []int{}}
+		i := slices.IndexFunc(nics, func(nic Nic) bool { return nic.Interface == iface })
+		if i >= 0 {
+			state.numaNodes = numaNodesOf(nics[i : i+1])
+		} else if old, ok := manager.resources[iface]; ok {
+			state.numaNodes = old.numaNodes
+		}
+		states[iface] = state
+		reasons[iface] = err
+	}
+	return states, reasons
+}
+
+// setResources updates the devices of each resource to match the given
+// states, logging why any have become unhealthy. Returns true if any devices
+// have changed.
+func (manager *NicManager) setResources(
+	states map[string]resourceState,
+	reasons map[string]error,
+) bool {
+	changed := false
+	for iface, state := range states {
+		old, ok := manager.resources[iface]
+		if ok && old.health == state.health && slices.Equal(old.numaNodes, state.numaNodes) {
+			continue
+		}
+		changed = true
+
+		name := manager.resourceNameFor(iface)
+		if !ok || old.health != state.health {
+			if reasons[iface] != nil {
+				glog.Warningf("%s devices are %s (%v)", name, state.health, reasons[iface])
+			} else {
+				glog.Infof("%s devices are %s", name, state.health)
+			}
+		}
+		if ok && !slices.Equal(old.numaNodes, state.numaNodes) {
+			glog.Infof("%s devices are now on NUMA nodes %v", name, state.numaNodes)
+		}
+		manager.devices[iface] = manager.makeDevices(state.health, state.numaNodes)
+	}
+	manager.resources = states
+	manager.reasons = reasons
+	return changed
+}
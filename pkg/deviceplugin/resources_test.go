@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("Testing per-interface resources", func() {
+	var (
+		manager *NicManager
+		nics    []Nic
+	)
+
+	healthOfDevices := func(iface string) string {
+		devices, _ := manager.watchDevices(iface)
+		Expect(devices).Should(HaveLen(2))
+		Expect(devices).Should(HaveEach(HaveField("Health", devices[0].Health)))
+		return devices[0].Health
+	}
+
+	BeforeEach(func() {
+		nics = []Nic{fakeNic("enp2s0f0", 0, true), fakeNic("enp65s0f0", 1, false)}
+		manager = &NicManager{
+			nics:   nics,
+			config: newFakeHost(),
+			envs:   map[string]string{"LD_PRELOAD": "libonload.so"},
+			queryNics: func() ([]Nic, error) {
+				return nics, nil
+			},
+		}
+		manager.config.MaxPodsPerNode = 2
+		manager.config.PerInterface = true
+		manager.initDevices()
+	})
+
+	It("should advertise a resource for each interface", func() {
+		Expect(manager.resources).Should(HaveLen(2))
+		Expect(manager.getDevices()).Should(BeEmpty())
+		Expect(healthOfDevices("enp2s0f0")).Should(Equal(pluginapi.Healthy))
+		Expect(healthOfDevices("enp65s0f0")).Should(Equal(pluginapi.Unhealthy))
+
+		devices, _ := manager.watchDevices("enp65s0f0")
+		Expect(devices).Should(HaveEach(
+			HaveField("Topology.Nodes", ConsistOf(HaveField("ID", int64(1))))))
+	})
+
+	It("should name the resources after the interfaces", func() {
+		Expect(manager.resourceNameFor("")).Should(Equal("amd.com/onload"))
+		Expect(manager.resourceNameFor("enp2s0f0")).Should(Equal("amd.com/onload-enp2s0f0"))
+		Expect(NewRPCServer(manager, "enp2s0f0").listenSockPath).
+			Should(HaveSuffix("/sfc-deviceplugin-enp2s0f0.sock"))
+	})
+
+	It("should name the resources and sockets after a configured resource name", func() {
+		manager.config.ResourceName = "amd.com/enterprise-onload"
+		Expect(manager.resourceNameFor("")).Should(Equal("amd.com/enterprise-onload"))
+		Expect(manager.resourceNameFor("enp2s0f0")).Should(Equal("amd.com/enterprise-onload-enp2s0f0"))
+		Expect(NewRPCServer(manager, "").listenSockPath).
+			Should(HaveSuffix("/amd.com_enterprise-onload-deviceplugin.sock"))
+		Expect(NewRPCServer(manager, "enp2s0f0").listenSockPath).
+			Should(HaveSuffix("/amd.com_enterprise-onload-deviceplugin-enp2s0f0.sock"))
+		Expect(NewRPCServer(manager, "amd.com/tcpdirect").listenSockPath).
+			Should(HaveSuffix("/tcpdirect-amd.com_enterprise-onload-deviceplugin.sock"))
+		Expect(manager.ownsResource("amd.com/enterprise-onload")).Should(BeTrue())
+		Expect(manager.ownsResource("amd.com/enterprise-onload-enp2s0f0")).Should(BeTrue())
+		Expect(manager.ownsResource("amd.com/enterprise-onload-enp2s0f1")).Should(BeFalse())
+		Expect(manager.ownsResource("amd.com/onload")).Should(BeFalse())
+	})
+
+	It("should follow the link state of each interface", func() {
+		nics = []Nic{fakeNic("enp2s0f0", 0, false), fakeNic("enp65s0f0", 1, true)}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(healthOfDevices("enp2s0f0")).Should(Equal(pluginapi.Unhealthy))
+		Expect(healthOfDevices("enp65s0f0")).Should(Equal(pluginapi.Healthy))
+	})
+
+	It("should keep the resources of interfaces that go away", func() {
+		nics = []Nic{fakeNic("enp65s0f0", 1, true)}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.resources).Should(HaveKey("enp2s0f0"))
+		Expect(healthOfDevices("enp2s0f0")).Should(Equal(pluginapi.Unhealthy))
+	})
+
+	It("should add resources for new interfaces", func() {
+		nics = append(nics, fakeNic("enp2s0f1", 0, true))
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(healthOfDevices("enp2s0f1")).Should(Equal(pluginapi.Healthy))
+	})
+
+	It("should skip interfaces which can't name a resource", func() {
+		nics = append(nics, fakeNic("enp2s0f1@", 0, true))
+		Expect(manager.updateHealth()).Should(BeFalse())
+		Expect(manager.resources).ShouldNot(HaveKey("enp2s0f1@"))
+	})
+
+	It("should restrict onload to the allocated interface", func() {
+		req := &pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+				{DevicesIDs: []string{"sfc-0"}},
+			},
+		}
+
+		resp, err := NewRPCServer(manager, "enp2s0f0").Allocate(context.Background(), req)
+		Expect(err).Should(Succeed())
+		Expect(resp.ContainerResponses[0].Envs).Should(Equal(map[string]string{
+			"LD_PRELOAD":             "libonload.so",
+			"EF_INTERFACE_WHITELIST": "enp2s0f0",
+		}))
+		Expect(manager.envs).ShouldNot(HaveKey("EF_INTERFACE_WHITELIST"))
+
+		resp, err = NewRPCServer(manager, "").Allocate(context.Background(), req)
+		Expect(err).Should(Succeed())
+		Expect(resp.ContainerResponses[0].Envs).ShouldNot(HaveKey("EF_INTERFACE_WHITELIST"))
+	})
+})
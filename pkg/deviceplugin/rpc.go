@@ -4,25 +4,41 @@ package deviceplugin
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
+// How long to wait for in-flight RPCs to finish when stopping the RPC server,
+// before closing their connections.
+const gracefulStopTimeout = 5 * time.Second
+
+// How long to wait before retrying a failed registration with the kubelet,
+// doubling after each failure up to the maximum.
+const (
+	registerRetryInitialDelay = 1 * time.Second
+	registerRetryMaxDelay     = 30 * time.Second
+)
+
 func dialUnix(ctx context.Context, path string) (net.Conn, error) {
 	return net.DialTimeout("unix", path, 5*time.Second)
 }
 
 // Specialises grpc.Dial to use a domain socket and accept a timeout
-func grpcDial(sockPath string, timeout time.Duration) (*grpc.ClientConn, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+func grpcDial(ctx context.Context, sockPath string, timeout time.Duration) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	return grpc.DialContext(
 		ctx,
@@ -33,57 +49,155 @@ func grpcDial(sockPath string, timeout time.Duration) (*grpc.ClientConn, error)
 	)
 }
 
-func getRPCSockPath() string {
-	sockPath := path.Join(pluginapi.DevicePluginPath, "sfc-deviceplugin.sock")
+// Returns the socket path, in the given directory, of the RPC server for the
+// resource restricted to the given interface, for the shared resource if it
+// is "", or for the API resource with that name. The sockets of a resource not
+// named the default are named after it so that they can't clash with another
+// flavour's. Those of API resources are also named after the resource, but
+// start with the API's name so that they can't clash with an interface's.
+func getRPCSockPath(dir string, resourceName string, iface string) string {
+	prefix := "sfc-deviceplugin"
+	if resourceName != defaultResourceName {
+		prefix = strings.ReplaceAll(resourceName, "/", "_") + "-deviceplugin"
+	}
+	sockName := prefix + ".sock"
+	if res, ok := apiResourceFor(iface); ok {
+		sockName = fmt.Sprintf("%s-%s.sock", res.cdiDevice, prefix)
+	} else if iface != "" {
+		sockName = fmt.Sprintf("%s-%s.sock", prefix, iface)
+	}
+	sockPath := path.Join(dir, sockName)
 	glog.Infof("RPC socket path is %s", sockPath)
 	return sockPath
 }
 
-// RPCServer runs a gRPC server talking the device plugin API
+// RPCServer runs a gRPC server talking the device plugin API for one resource
 type RPCServer struct {
-	manager        *NicManager
-	listenSockPath string
+	manager *NicManager
+	// resourceName is the name of the resource served.
+	resourceName string
+	// iface is the interface the resource is restricted to, "" if the
+	// resource is shared by all interfaces, or the name of an API resource.
+	iface           string
+	listenSockPath  string
+	kubeletSockPath string
+	serveErr        chan error
+
+	// mu protects server and stopping, which are replaced each time the
+	// server is started, and registeredAt and registerErr.
+	mu     sync.Mutex
+	server *grpc.Server
+	// stopping is closed when the server starts to stop, ending the
+	// ListAndWatch streams which would otherwise hold up a graceful stop.
+	stopping chan struct{}
+	// registeredAt is when the server was registered with the kubelet, or
+	// zero if it isn't currently registered.
+	registeredAt time.Time
+	// registerErr is why registering last failed, or nil if it didn't.
+	registerErr error
 }
 
-// NewRPCServer initialises (but does not start) a new RPC server
-func NewRPCServer(manager *NicManager) *RPCServer {
-	manager.wg.Add(1)
+// NewRPCServer initialises (but does not start) a new RPC server for the
+// resource restricted to the given interface, the shared resource if it is
+// "", or the API resource with that name. Once the manager is running, it must
+// be called with the manager's lock held, as it reads the config.
+func NewRPCServer(manager *NicManager, iface string) *RPCServer {
+	dir := manager.pluginDir
+	if dir == "" {
+		dir = pluginapi.DevicePluginPath
+	}
 	return &RPCServer{
-		manager:        manager,
-		listenSockPath: getRPCSockPath(),
+		manager:         manager,
+		resourceName:    manager.resourceNameFor(iface),
+		iface:           iface,
+		listenSockPath:  getRPCSockPath(dir, manager.config.ResourceName, iface),
+		kubeletSockPath: path.Join(dir, filepath.Base(pluginapi.KubeletSocket)),
+		serveErr:        make(chan error, 1),
 	}
 }
 
-// Serve Sets up a listening socket and serves grpc on it
-func (rpc *RPCServer) Serve() {
-	defer rpc.manager.wg.Done()
-
+// start sets up a listening socket and serves grpc on it in the background
+func (rpc *RPCServer) start() error {
 	err := os.RemoveAll(rpc.listenSockPath)
 	if err != nil {
-		glog.Fatalf("Failed to delete old socket %s (%v)", rpc.listenSockPath, err)
+		return fmt.Errorf("failed to delete old socket %s (%w)", rpc.listenSockPath, err)
 	}
 
 	err = os.MkdirAll(filepath.Dir(rpc.listenSockPath), os.ModePerm)
 	if err != nil {
-		glog.Fatalf("Failed to create directory for socket %s (%v)", rpc.listenSockPath, err)
+		return fmt.Errorf("failed to create directory for socket %s (%w)", rpc.listenSockPath, err)
 	}
 
 	listenSock, err := net.Listen("unix", rpc.listenSockPath)
 	if err != nil {
-		glog.Fatalf("Failed to listen on %s (%v)", rpc.listenSockPath, err)
+		return fmt.Errorf("failed to listen on %s (%w)", rpc.listenSockPath, err)
 	}
 
-	grpcServer := grpc.NewServer([]grpc.ServerOption{}...)
-	pluginapi.RegisterDevicePluginServer(grpcServer, rpc)
+	server := grpc.NewServer([]grpc.ServerOption{}...)
+	pluginapi.RegisterDevicePluginServer(server, rpc)
+	rpc.mu.Lock()
+	rpc.server = server
+	rpc.stopping = make(chan struct{})
+	rpc.mu.Unlock()
 	glog.Infof("RPC server listening on %s", rpc.listenSockPath)
-	err = grpcServer.Serve(listenSock)
-	if err != nil {
-		glog.Fatalf("grpcServer.Serve failed (%v)", err)
+
+	go func() {
+		// Serve only returns nil once stopped.
+		err := server.Serve(listenSock)
+		if err != nil {
+			rpc.serveErr <- fmt.Errorf("grpcServer.Serve failed (%w)", err)
+		}
+	}()
+
+	return nil
+}
+
+// stoppingChan returns a channel which is closed when the server starts to
+// stop.
+func (rpc *RPCServer) stoppingChan() <-chan struct{} {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	return rpc.stopping
+}
+
+// stop stops the grpc server, giving in-flight RPCs a chance to finish, and
+// removes its socket.
+func (rpc *RPCServer) stop() {
+	rpc.mu.Lock()
+	server := rpc.server
+	rpc.server = nil
+	if server != nil {
+		close(rpc.stopping)
+	}
+	rpc.registeredAt = time.Time{}
+	rpc.mu.Unlock()
+	if server == nil {
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(gracefulStopTimeout):
+		glog.Warningf("RPC server %s did not stop gracefully, closing connections",
+			rpc.listenSockPath)
+		server.Stop()
+	}
+
+	// Closing the listener should remove the socket, but make sure, as the
+	// kubelet would otherwise try to talk to it.
+	err := os.Remove(rpc.listenSockPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		glog.Warningf("Failed to remove socket %s (%v)", rpc.listenSockPath, err)
 	}
 }
 
-func (rpc *RPCServer) isUp() bool {
-	conn, err := grpcDial(rpc.listenSockPath, 100*time.Millisecond)
+func (rpc *RPCServer) isUp(ctx context.Context) bool {
+	conn, err := grpcDial(ctx, rpc.listenSockPath, 100*time.Millisecond)
 	if err != nil {
 		glog.Infof("RPC server is not up (%v)", err)
 		return false
@@ -93,24 +207,73 @@ func (rpc *RPCServer) isUp() bool {
 	return true
 }
 
-// WaitUntilUp waits until the RPC server is up
-func (rpc *RPCServer) WaitUntilUp() {
-	for !rpc.isUp() {
-		time.Sleep(1 * time.Second)
+// WaitUntilUp waits until the RPC server is up, or ctx is cancelled
+func (rpc *RPCServer) WaitUntilUp(ctx context.Context) error {
+	for !rpc.isUp(ctx) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
 	}
+	return nil
 }
 
 // Register the device plugin with the kubernetes API
-func (rpc *RPCServer) Register() {
-	glog.Infof("Connecting to kubelet sock %s", pluginapi.KubeletSocket)
-	conn, err := grpcDial(pluginapi.KubeletSocket, 5*time.Second)
+func (rpc *RPCServer) Register(ctx context.Context) error {
+	err := rpc.register(ctx)
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	rpc.registerErr = err
+	if err == nil {
+		rpc.registeredAt = time.Now()
+	}
+	return err
+}
+
+// registerWithRetry registers the device plugin with the kubelet, retrying
+// with backoff until it succeeds, as the kubelet won't accept connections
+// while it is restarting. Returns early if the server fails or ctx is
+// cancelled.
+func (rpc *RPCServer) registerWithRetry(ctx context.Context) error {
+	delay := registerRetryInitialDelay
+	for {
+		err := rpc.Register(ctx)
+		if err == nil {
+			return nil
+		}
+
+		glog.Warningf("Failed to register %s, retrying in %v (%v)", rpc.resourceName, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-rpc.serveErr:
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, registerRetryMaxDelay)
+	}
+}
+
+// registration returns when the server was registered with the kubelet, or
+// zero if it isn't currently registered, and why registering last failed.
+func (rpc *RPCServer) registration() (time.Time, error) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	return rpc.registeredAt, rpc.registerErr
+}
+
+func (rpc *RPCServer) register(ctx context.Context) error {
+	registrationsTotal.WithLabelValues(rpc.resourceName).Inc()
+	glog.Infof("Connecting to kubelet sock %s", rpc.kubeletSockPath)
+	conn, err := grpcDial(ctx, rpc.kubeletSockPath, 5*time.Second)
 	if err != nil {
-		glog.Fatalf("Failed to connect to kubelet socket %s (%v)", pluginapi.KubeletSocket, err)
+		registrationFailuresTotal.WithLabelValues(rpc.resourceName).Inc()
+		return fmt.Errorf("failed to connect to kubelet socket %s (%w)", rpc.kubeletSockPath, err)
 	}
 	defer conn.Close()
 
-	opts, err := rpc.GetDevicePluginOptions(context.Background(),
-		&pluginapi.Empty{})
+	opts, err := rpc.GetDevicePluginOptions(ctx, &pluginapi.Empty{})
 	if err != nil {
 		glog.Warningf("Failed to get DevicePluginOptions (%v)", err)
 		// It should be fine to continue, as the device manager will call
@@ -122,12 +285,109 @@ func (rpc *RPCServer) Register() {
 	req := &pluginapi.RegisterRequest{
 		Version:      pluginapi.Version,
 		Endpoint:     filepath.Base(rpc.listenSockPath),
-		ResourceName: resourceName,
+		ResourceName: rpc.resourceName,
 		Options:      opts,
 	}
 
-	_, err = client.Register(context.Background(), req)
+	_, err = client.Register(ctx, req)
 	if err != nil {
-		glog.Fatalf("Failed to register device plugin (%v)", err)
+		registrationFailuresTotal.WithLabelValues(rpc.resourceName).Inc()
+		return fmt.Errorf("failed to register device plugin (%w)", err)
+	}
+	glog.Infof("Registered device plugin for %s", rpc.resourceName)
+	return nil
+}
+
+// waitForKubeletRestart blocks until the kubelet has restarted, which is
+// noticed either by the kubelet recreating its socket or by it deleting the
+// sockets of the registered device plugins. Returns nil if the server should
+// be restarted and re-registered.
+func (rpc *RPCServer) waitForKubeletRestart(ctx context.Context, watcher *fsnotify.Watcher) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-rpc.serveErr:
+			return err
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("socket watcher closed")
+			}
+			return fmt.Errorf("socket watcher failed (%w)", err)
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("socket watcher closed")
+			}
+
+			switch {
+			case event.Name == rpc.kubeletSockPath && event.Has(fsnotify.Create):
+				glog.Infof("Kubelet socket %s created, kubelet has restarted", event.Name)
+				return nil
+
+			case event.Name == rpc.listenSockPath && event.Has(fsnotify.Remove):
+				// Ignore the removal of a previous socket by start.
+				if _, err := os.Stat(rpc.listenSockPath); err == nil {
+					continue
+				}
+				// The kubelet deletes our socket before creating its own, so
+				// wait for that unless it is already there.
+				if _, err := os.Stat(rpc.kubeletSockPath); err != nil {
+					glog.Infof("RPC socket %s removed, waiting for kubelet", event.Name)
+					continue
+				}
+				glog.Infof("RPC socket %s removed", event.Name)
+				return nil
+			}
+		}
+	}
+}
+
+// Run serves the device plugin API and registers it with the kubelet. The
+// server is rebuilt and re-registered whenever the kubelet restarts, as the
+// kubelet forgets about device plugins when it does. Failing to register is
+// retried, so only returns if the server fails, or when ctx is cancelled.
+func (rpc *RPCServer) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create socket watcher (%w)", err)
+	}
+	defer watcher.Close()
+
+	socketDir := filepath.Dir(rpc.listenSockPath)
+	err = os.MkdirAll(socketDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for socket %s (%w)", rpc.listenSockPath, err)
+	}
+	err = watcher.Add(socketDir)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s (%w)", socketDir, err)
+	}
+
+	defer rpc.stop()
+	for {
+		err = rpc.start()
+		if err != nil {
+			return err
+		}
+		err = rpc.WaitUntilUp(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = rpc.registerWithRetry(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = rpc.waitForKubeletRestart(ctx, watcher)
+		if err != nil {
+			return err
+		}
+
+		glog.Info("Restarting RPC server")
+		rpc.stop()
 	}
 }
@@ -0,0 +1,247 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// fakeKubelet implements the kubelet's device plugin Registration service.
+type fakeKubelet struct {
+	server   *grpc.Server
+	requests chan *pluginapi.RegisterRequest
+}
+
+func (kubelet *fakeKubelet) Register(
+	ctx context.Context,
+	req *pluginapi.RegisterRequest,
+) (*pluginapi.Empty, error) {
+	kubelet.requests <- req
+	return &pluginapi.Empty{}, nil
+}
+
+// startFakeKubelet mimics a kubelet (re)starting by deleting all the sockets
+// in the device plugin directory before serving on its own socket.
+func startFakeKubelet(sockPath string, requests chan *pluginapi.RegisterRequest) *fakeKubelet {
+	dir := path.Dir(sockPath)
+	entries, err := os.ReadDir(dir)
+	Expect(err).Should(Succeed())
+	for _, entry := range entries {
+		Expect(os.Remove(path.Join(dir, entry.Name()))).Should(Succeed())
+	}
+
+	listener, err := net.Listen("unix", sockPath)
+	Expect(err).Should(Succeed())
+
+	kubelet := &fakeKubelet{
+		server:   grpc.NewServer(),
+		requests: requests,
+	}
+	pluginapi.RegisterRegistrationServer(kubelet.server, kubelet)
+	go func() {
+		defer GinkgoRecover()
+		Expect(kubelet.server.Serve(listener)).Should(Succeed())
+	}()
+	return kubelet
+}
+
+var _ = Describe("Testing registration with the kubelet", func() {
+	var (
+		tmpDir      string
+		kubeletSock string
+		pluginSock  string
+		requests    chan *pluginapi.RegisterRequest
+		rpc         *RPCServer
+		ctx         context.Context
+		cancel      context.CancelFunc
+		runErr      chan error
+		done        chan struct{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "device-plugins")
+		Expect(err).Should(Succeed())
+		DeferCleanup(os.RemoveAll, tmpDir)
+
+		kubeletSock = path.Join(tmpDir, "kubelet.sock")
+		pluginSock = path.Join(tmpDir, "sfc-deviceplugin.sock")
+		requests = make(chan *pluginapi.RegisterRequest, 10)
+
+		manager := &NicManager{config: DefaultConfig}
+		manager.initDevices()
+		rpc = &RPCServer{
+			manager:         manager,
+			resourceName:    defaultResourceName,
+			listenSockPath:  pluginSock,
+			kubeletSockPath: kubeletSock,
+			serveErr:        make(chan error, 1),
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		runErr = make(chan error, 1)
+		done = make(chan struct{})
+		DeferCleanup(func() {
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	run := func() {
+		ctx, rpc, runErr, done := ctx, rpc, runErr, done
+		go func() {
+			defer close(done)
+			runErr <- rpc.Run(ctx)
+		}()
+	}
+
+	It("should register with the kubelet", func() {
+		kubelet := startFakeKubelet(kubeletSock, requests)
+		defer kubelet.server.Stop()
+
+		run()
+
+		var req *pluginapi.RegisterRequest
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.ResourceName).Should(Equal(defaultResourceName))
+		Expect(req.Endpoint).Should(Equal("sfc-deviceplugin.sock"))
+		Expect(req.Version).Should(Equal(pluginapi.Version))
+	})
+
+	It("should re-register after the kubelet restarts", func() {
+		kubelet := startFakeKubelet(kubeletSock, requests)
+
+		run()
+		Eventually(requests).Should(Receive())
+
+		kubelet.server.Stop()
+		kubelet = startFakeKubelet(kubeletSock, requests)
+		defer kubelet.server.Stop()
+
+		Eventually(requests, "10s").Should(Receive())
+		Eventually(pluginSock).Should(BeAnExistingFile())
+		Expect(rpc.isUp(ctx)).Should(BeTrue())
+	})
+
+	It("should stop and remove its socket when cancelled", func() {
+		kubelet := startFakeKubelet(kubeletSock, requests)
+		defer kubelet.server.Stop()
+
+		run()
+		Eventually(requests).Should(Receive())
+		Expect(pluginSock).Should(BeAnExistingFile())
+
+		cancel()
+		Eventually(runErr).Should(Receive(MatchError(context.Canceled)))
+		Expect(pluginSock).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should end ListAndWatch streams when stopping", func() {
+		Expect(rpc.start()).Should(Succeed())
+
+		conn, err := grpcDial(ctx, pluginSock, 5*time.Second)
+		Expect(err).Should(Succeed())
+		defer conn.Close()
+		stream, err := pluginapi.NewDevicePluginClient(conn).ListAndWatch(ctx, &pluginapi.Empty{})
+		Expect(err).Should(Succeed())
+		_, err = stream.Recv()
+		Expect(err).Should(Succeed())
+
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			rpc.stop()
+		}()
+		Eventually(stopped).WithTimeout(gracefulStopTimeout / 2).Should(BeClosed())
+		_, err = stream.Recv()
+		Expect(err).Should(MatchError(io.EOF))
+		Expect(pluginSock).ShouldNot(BeAnExistingFile())
+		// Run wasn't used, so there is nothing to wait for.
+		close(done)
+	})
+
+	It("should keep trying to register until the kubelet is running", func() {
+		failures := testutil.ToFloat64(registrationFailuresTotal.WithLabelValues(defaultResourceName))
+		run()
+		Eventually(func() float64 {
+			return testutil.ToFloat64(registrationFailuresTotal.WithLabelValues(defaultResourceName))
+		}, "10s").Should(BeNumerically(">", failures))
+		Expect(rpc.registration()).Error().Should(MatchError(ContainSubstring(
+			"failed to connect to kubelet socket")))
+		Expect(runErr).ShouldNot(Receive())
+
+		kubelet := startFakeKubelet(kubeletSock, requests)
+		defer kubelet.server.Stop()
+		Eventually(requests, "10s").Should(Receive())
+		Expect(rpc.registration()).Error().Should(BeNil())
+	})
+})
+
+var _ = Describe("Testing running the device plugin", func() {
+	var (
+		manager     *NicManager
+		kubeletSock string
+		requests    chan *pluginapi.RegisterRequest
+		ctx         context.Context
+		cancel      context.CancelFunc
+		runErr      chan error
+	)
+
+	BeforeEach(func() {
+		config := newFakeHost()
+		config.PodResourcesSocket = ""
+		var err error
+		manager, err = NewNicManager(config)
+		Expect(err).Should(Succeed())
+		manager.pluginDir = GinkgoT().TempDir()
+
+		kubeletSock = path.Join(manager.pluginDir, "kubelet.sock")
+		requests = make(chan *pluginapi.RegisterRequest, 10)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		runErr = make(chan error, 1)
+	})
+
+	run := func() {
+		manager, ctx, runErr := manager, ctx, runErr
+		go func() {
+			runErr <- manager.Run(ctx)
+		}()
+	}
+
+	It("should stop cleanly when cancelled", func() {
+		kubelet := startFakeKubelet(kubeletSock, requests)
+		defer kubelet.server.Stop()
+
+		run()
+		Eventually(requests).Should(Receive())
+
+		cancel()
+		Eventually(runErr).Should(Receive(BeNil()))
+		Expect(path.Join(manager.pluginDir, "sfc-deviceplugin.sock")).ShouldNot(BeAnExistingFile())
+	})
+
+	It("should keep running until the kubelet is running", func() {
+		run()
+		Consistently(runErr, "2s").ShouldNot(Receive())
+
+		kubelet := startFakeKubelet(kubeletSock, requests)
+		defer kubelet.server.Stop()
+		Eventually(requests, "10s").Should(Receive())
+
+		cancel()
+		Eventually(runErr).Should(Receive(BeNil()))
+	})
+})
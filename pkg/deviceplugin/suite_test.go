@@ -3,8 +3,14 @@
 package deviceplugin
 
 import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
 	"testing"
 )
@@ -13,3 +19,61 @@ func TestOnload(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Onload Device Plugin Suite")
 }
+
+// newFakeHost returns a config for a host, faked in temporary directories,
+// with onload installed, loaded and its control plane configured, and an sfc
+// interface with link up.
+func newFakeHost() NicManagerConfig {
+	create := func(file string) {
+		Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(Succeed())
+		Expect(os.WriteFile(file, []byte{}, 0644)).Should(Succeed())
+	}
+
+	config := DefaultConfig
+	config.SysfsRoot = newFakeSysfs(fakeInterface{
+		name: "enp2s0f0", pciAddress: "0000:02:00.0",
+		vendor: "0x1924", driver: "sfc", numaNode: "0", operState: "up",
+	})
+	moduleDir := filepath.Join(config.SysfsRoot, "module", "onload")
+	Expect(os.MkdirAll(moduleDir, os.ModePerm)).Should(Succeed())
+	Expect(os.MkdirAll(filepath.Join(moduleDir, "parameters"), os.ModePerm)).Should(Succeed())
+	Expect(os.WriteFile(filepath.Join(moduleDir, "parameters", cplaneServerParams),
+		[]byte("exec 0123abcd /mnt/onload/sbin/onload_cp_server -K\n"), 0644)).Should(Succeed())
+
+	config.DevRoot = GinkgoT().TempDir()
+	for _, device := range deviceMounts {
+		create(filepath.Join(config.DevRoot, device))
+	}
+
+	config.HostPathPrefix = GinkgoT().TempDir()
+	for _, library := range libraryMounts {
+		create(path.Join(config.HostPathPrefix, hostLib64path, library))
+	}
+
+	return config
+}
+
+// fakeNic returns an sfc interface with the given NUMA node and link state.
+func fakeNic(name string, numaNode int, linkUp bool) Nic {
+	return Nic{Interface: name, Vendor: "0x1924", Driver: "sfc", NUMANode: numaNode, LinkUp: linkUp}
+}
+
+// allocate returns the response to allocating a device to a container from
+// the RPC server for the interface.
+func allocate(manager *NicManager, iface string) *pluginapi.ContainerAllocateResponse {
+	return allocateFrom(NewRPCServer(manager, iface))
+}
+
+// allocateFrom returns the response to allocating a device to a container from
+// the RPC server.
+func allocateFrom(rpc *RPCServer) *pluginapi.ContainerAllocateResponse {
+	resp, err := rpc.Allocate(context.Background(),
+		&pluginapi.AllocateRequest{
+			ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+				{DevicesIDs: []string{"sfc-0"}},
+			},
+		})
+	Expect(err).Should(Succeed())
+	Expect(resp.ContainerResponses).Should(HaveLen(1))
+	return resp.ContainerResponses[0]
+}
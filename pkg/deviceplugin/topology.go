@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"slices"
+	"sort"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// noNUMANode is the NUMA node of devices without topology information.
+const noNUMANode = -1
+
+// Returns the distinct NUMA nodes local to the given NICs, in ascending
+// order. NICs whose NUMA node is unknown are ignored.
+func numaNodesOf(nics []Nic) []int {
+	nodes := []int{}
+	for _, nic := range nics {
+		if nic.NUMANode >= 0 && !slices.Contains(nodes, nic.NUMANode) {
+			nodes = append(nodes, nic.NUMANode)
+		}
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// Returns the topology of the i'th device when the devices are partitioned,
+// round robin, across the given NUMA nodes. Returns nil if there are none, as
+// the kubelet then treats the device as having no NUMA affinity.
+func deviceTopology(i int, numaNodes []int) *pluginapi.TopologyInfo {
+	if len(numaNodes) == 0 {
+		return nil
+	}
+	return &pluginapi.TopologyInfo{
+		Nodes: []*pluginapi.NUMANode{
+			{ID: int64(numaNodes[i%len(numaNodes)])},
+		},
+	}
+}
+
+// Returns the NUMA node of the device, or noNUMANode.
+func deviceNUMANode(device *pluginapi.Device) int {
+	if device.Topology == nil || len(device.Topology.Nodes) == 0 {
+		return noNUMANode
+	}
+	return int(device.Topology.Nodes[0].ID)
+}
+
+// preferredAllocation chooses which of the available devices to allocate to a
+// container, keeping them on as few NUMA nodes as possible. The NUMA nodes of
+// the devices that must be included are used first, followed by those able to
+// satisfy the rest of the request on their own, then those with the most
+// devices available.
+func preferredAllocation(
+	devices []*pluginapi.Device,
+	req *pluginapi.ContainerPreferredAllocationRequest,
+) []string {
+	size := int(req.AllocationSize)
+
+	// Order the devices as advertised, rather than by ID, so that sfc-10
+	// comes after sfc-9.
+	order := map[string]int{}
+	numaNodes := map[string]int{}
+	for i, device := range devices {
+		order[device.ID] = i
+		numaNodes[device.ID] = deviceNUMANode(device)
+	}
+	nodeOf := func(id string) int {
+		if node, ok := numaNodes[id]; ok {
+			return node
+		}
+		return noNUMANode
+	}
+
+	allocation := []string{}
+	mustNodes := map[int]int{}
+	for _, id := range req.MustIncludeDeviceIDs {
+		if len(allocation) < size {
+			allocation = append(allocation, id)
+			mustNodes[nodeOf(id)]++
+		}
+	}
+
+	available := map[int][]string{}
+	for _, id := range req.AvailableDeviceIDs {
+		if slices.Contains(req.MustIncludeDeviceIDs, id) {
+			continue
+		}
+		node := nodeOf(id)
+		available[node] = append(available[node], id)
+	}
+	nodes := []int{}
+	for node, ids := range available {
+		sort.SliceStable(ids, func(i, j int) bool {
+			return order[ids[i]] < order[ids[j]]
+		})
+		nodes = append(nodes, node)
+	}
+
+	needed := size - len(allocation)
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if mustNodes[a] != mustNodes[b] {
+			return mustNodes[a] > mustNodes[b]
+		}
+		aFits, bFits := len(available[a]) >= needed, len(available[b]) >= needed
+		if aFits != bFits {
+			return aFits
+		}
+		if aFits {
+			// Leave the nodes with the most devices for larger requests.
+			if len(available[a]) != len(available[b]) {
+				return len(available[a]) < len(available[b])
+			}
+		} else if len(available[a]) != len(available[b]) {
+			return len(available[a]) > len(available[b])
+		}
+		return a < b
+	})
+
+	for _, node := range nodes {
+		for _, id := range available[node] {
+			if len(allocation) == size {
+				return allocation
+			}
+			allocation = append(allocation, id)
+		}
+	}
+	return allocation
+}
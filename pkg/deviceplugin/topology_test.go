@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+// SPDX-FileCopyrightText: (c) Copyright 2024 Advanced Micro Devices, Inc.
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var _ = Describe("Testing NUMA topology", func() {
+	var (
+		manager *NicManager
+		nics    []Nic
+	)
+
+	deviceIDs := func(first, last int) []string {
+		ids := []string{}
+		for i := first; i <= last; i++ {
+			ids = append(ids, fmt.Sprintf("sfc-%d", i))
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		nics = []Nic{
+			fakeNic("enp2s0f0", 0, true), fakeNic("enp2s0f1", 0, true), fakeNic("enp65s0f0", 1, true),
+		}
+		manager = &NicManager{
+			nics:   nics,
+			config: newFakeHost(),
+			queryNics: func() ([]Nic, error) {
+				return nics, nil
+			},
+		}
+		manager.config.MaxPodsPerNode = 6
+		manager.initDevices()
+	})
+
+	It("should partition the devices across the NUMA nodes of the NICs", func() {
+		nodes := []int{}
+		for _, device := range manager.getDevices() {
+			Expect(device.Topology.Nodes).Should(HaveLen(1))
+			nodes = append(nodes, int(device.Topology.Nodes[0].ID))
+		}
+		Expect(nodes).Should(Equal([]int{0, 1, 0, 1, 0, 1}))
+	})
+
+	It("should not give topology if the NUMA nodes are unknown", func() {
+		nics = []Nic{fakeNic("enp2s0f0", -1, true)}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.getDevices()).Should(HaveEach(HaveField("Topology", BeNil())))
+	})
+
+	It("should update the topology when the NICs move", func() {
+		nics = []Nic{fakeNic("enp65s0f0", 1, true)}
+		Expect(manager.updateHealth()).Should(BeTrue())
+		Expect(manager.getDevices()).Should(HaveEach(
+			HaveField("Topology.Nodes", ConsistOf(HaveField("ID", int64(1))))))
+
+		Expect(manager.updateHealth()).Should(BeFalse())
+	})
+
+	Context("preferring an allocation", func() {
+		var rpc *RPCServer
+
+		BeforeEach(func() {
+			rpc = &RPCServer{manager: manager}
+		})
+
+		prefer := func(available []string, mustInclude []string, size int) []string {
+			resp, err := rpc.GetPreferredAllocation(context.Background(),
+				&pluginapi.PreferredAllocationRequest{
+					ContainerRequests: []*pluginapi.ContainerPreferredAllocationRequest{
+						{
+							AvailableDeviceIDs:   available,
+							MustIncludeDeviceIDs: mustInclude,
+							AllocationSize:       int32(size),
+						},
+					},
+				})
+			Expect(err).Should(Succeed())
+			Expect(resp.ContainerResponses).Should(HaveLen(1))
+			return resp.ContainerResponses[0].DeviceIDs
+		}
+
+		It("should advertise GetPreferredAllocation", func() {
+			opts, err := rpc.GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+			Expect(err).Should(Succeed())
+			Expect(opts.GetPreferredAllocationAvailable).Should(BeTrue())
+		})
+
+		It("should keep the devices on one NUMA node", func() {
+			Expect(prefer(deviceIDs(0, 5), nil, 2)).Should(Equal([]string{"sfc-0", "sfc-2"}))
+		})
+
+		It("should use the NUMA node of the devices that must be included", func() {
+			Expect(prefer(deviceIDs(0, 5), []string{"sfc-3"}, 3)).
+				Should(Equal([]string{"sfc-3", "sfc-1", "sfc-5"}))
+		})
+
+		It("should prefer a NUMA node which can satisfy the request", func() {
+			Expect(prefer([]string{"sfc-0", "sfc-1", "sfc-3", "sfc-5"}, nil, 2)).
+				Should(Equal([]string{"sfc-1", "sfc-3"}))
+		})
+
+		It("should span NUMA nodes if it must", func() {
+			Expect(prefer([]string{"sfc-0", "sfc-1", "sfc-3"}, nil, 3)).
+				Should(Equal([]string{"sfc-1", "sfc-3", "sfc-0"}))
+		})
+
+		It("should order devices as advertised", func() {
+			manager.config.MaxPodsPerNode = 12
+			manager.initDevices()
+			Expect(prefer([]string{"sfc-10", "sfc-8", "sfc-2"}, nil, 3)).
+				Should(Equal([]string{"sfc-2", "sfc-8", "sfc-10"}))
+		})
+	})
+})